@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+)
+
+/* security group audit.
+
+Checks every security group in a snapshot for:
+
+	open-sensitive-port  ingress from 0.0.0.0/0 on a port like ssh or postgres
+	broad-port-range     ingress on more than BroadPortRange ports
+	missing-group        rules referencing groups that no longer exist
+	unverifiable-group   rules referencing groups of another account or a peered VPC
+	duplicate-rule       rules granting exactly what another rule grants
+	shadowed-rule        rules granting a subset of what another rule grants
+	unattached-group     groups no instance or ELB uses
+
+A group of another account or a peered VPC usually isn't in the snapshot, so
+references to those are reported as unverifiable rather than missing.
+
+Security groups only allow, so a shadowed rule is never the one letting
+traffic through and can be removed.
+*/
+
+var UnknownSeverity = errors.New("Unknown severity, want low, medium or high!")
+
+// BroadPortRange is the number of ports above which a rule is flagged as broad.
+const BroadPortRange = 1000
+
+// Severity of a finding, higher is worse.
+type Severity int
+
+const (
+	Low Severity = iota
+	Medium
+	High
+)
+
+var severityNames = []string{"low", "medium", "high"}
+
+func (s Severity) String() string {
+	if int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// ParseSeverity returns the severity named name.
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if n == name {
+			return Severity(i), nil
+		}
+	}
+
+	return 0, UnknownSeverity
+}
+
+// MarshalText
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText
+func (s *Severity) UnmarshalText(text []byte) (err error) {
+	*s, err = ParseSeverity(string(text))
+	return err
+}
+
+// sarifLevel maps the severity to a SARIF result level.
+func (s Severity) sarifLevel() string {
+	switch s {
+	case High:
+		return "error"
+	case Medium:
+		return "warning"
+	}
+
+	return "note"
+}
+
+// AuditCheck is one of the checks an audit runs.
+type AuditCheck struct {
+	Id          string
+	Severity    Severity
+	Description string
+}
+
+// AuditChecks with their default severities.
+var AuditChecks = []AuditCheck{
+	{"open-sensitive-port", High, "Ingress open to 0.0.0.0/0 on a sensitive port"},
+	{"broad-port-range", Medium, "Ingress on a broad range of ports"},
+	{"missing-group", Medium, "Rule references a security group that doesn't exist"},
+	{"unverifiable-group", Low, "Rule references a security group outside the snapshot"},
+	{"duplicate-rule", Low, "Rule duplicates another rule of the group"},
+	{"shadowed-rule", Low, "Rule is covered by another rule of the group"},
+	{"unattached-group", Low, "Security group is attached to nothing"},
+}
+
+// SensitivePorts are the well known ports that shouldn't be open to the internet.
+var SensitivePorts = map[int64]string{
+	21:    "ftp",
+	22:    "ssh",
+	23:    "telnet",
+	445:   "smb",
+	1433:  "mssql",
+	1521:  "oracle",
+	2379:  "etcd",
+	3306:  "mysql",
+	3389:  "rdp",
+	5432:  "postgres",
+	5900:  "vnc",
+	6379:  "redis",
+	9200:  "elasticsearch",
+	11211: "memcached",
+	27017: "mongodb",
+}
+
+// Finding is a problem an audit found with a security group, and the rule at fault if any.
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Group    string   `json:"group"`
+	Rule     string   `json:"rule,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// AuditReport holds the findings of an audit, worst first.
+type AuditReport struct {
+	Findings []*Finding `json:"findings"`
+}
+
+// AtLeast returns the findings of severity min or worse.
+func (ar *AuditReport) AtLeast(min Severity) (findings []*Finding) {
+	for _, f := range ar.Findings {
+		if f.Severity >= min {
+			findings = append(findings, f)
+		}
+	}
+
+	return findings
+}
+
+// grant is one source of a security group rule.
+type grant struct {
+	egress   bool
+	protocol string
+	from, to int64
+	source   string
+	owner    string // account of a source group, empty for the group's own
+}
+
+func (g grant) String() string {
+	direction := "ingress"
+	if g.egress {
+		direction = "egress"
+	}
+
+	return fmt.Sprintf("%v %v %v", direction, PortRange{g.protocol, g.from, g.to}, g.source)
+}
+
+func (g grant) ports() int64 {
+	return g.to - g.from + 1
+}
+
+func permissionGrants(p *ec2.IPPermission, egress bool) (grants []grant) {
+	g := grant{egress: egress, protocol: normalizeProtocol(stringValue(p.IPProtocol)), from: 0, to: 65535}
+	if g.protocol != "all" && g.protocol != "icmp" && p.FromPort != nil && p.ToPort != nil && *p.FromPort >= 0 {
+		g.from, g.to = *p.FromPort, *p.ToPort
+	}
+
+	for _, ip := range p.IPRanges {
+		g.source = stringValue(ip.CIDRIP)
+		grants = append(grants, g)
+	}
+
+	for _, ug := range p.UserIDGroupPairs {
+		g.source, g.owner = stringValue(ug.GroupID), stringValue(ug.UserID)
+		grants = append(grants, g)
+	}
+
+	return grants
+}
+
+func groupGrants(sg *ec2.SecurityGroup) (grants []grant) {
+	for _, p := range sg.IPPermissions {
+		grants = append(grants, permissionGrants(p, false)...)
+	}
+
+	for _, p := range sg.IPPermissionsEgress {
+		grants = append(grants, permissionGrants(p, true)...)
+	}
+
+	return grants
+}
+
+// sourceCovers reports whether traffic from b always comes from a: the same group or a CIDR block within a.
+func sourceCovers(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	_, ab, err := net.ParseCIDR(a)
+	if err != nil {
+		return false
+	}
+
+	_, bb, err := net.ParseCIDR(b)
+	if err != nil {
+		return false
+	}
+
+	aPrefix, _ := ab.Mask.Size()
+	bPrefix, _ := bb.Mask.Size()
+
+	return aPrefix <= bPrefix && ab.Contains(bb.IP)
+}
+
+// covers reports whether a grants everything b grants.
+func (a grant) covers(b grant) bool {
+	return a.egress == b.egress &&
+		(a.protocol == "all" || a.protocol == b.protocol) &&
+		a.from <= b.from && b.to <= a.to &&
+		sourceCovers(a.source, b.source)
+}
+
+// AuditSecurityGroups runs the AuditChecks against the security groups of region.
+func AuditSecurityGroups(region *AwsRegion) (report *AuditReport) {
+	report = &AuditReport{}
+	add := func(check string, severity Severity, group string, rule string, format string, args ...interface{}) {
+		report.Findings = append(report.Findings, &Finding{check, severity, group, rule, fmt.Sprintf(format, args...)})
+	}
+
+	groups := make(map[string]*ec2.SecurityGroup)
+	for _, sg := range region.SecurityGroups {
+		groups[stringValue(sg.GroupID)] = sg
+	}
+
+	// groups of a peered VPC can be referenced, but aren't in the snapshot.
+	peered := make(map[string]bool)
+	for _, rt := range region.Routes {
+		for _, r := range rt.Routes {
+			if stringValue(r.VPCPeeringConnectionID) != "" {
+				peered[stringValue(rt.VPCID)] = true
+			}
+		}
+	}
+
+	attached := make(map[string]bool)
+	for _, i := range region.Instances {
+		for _, g := range i.SecurityGroups {
+			attached[stringValue(g.GroupID)] = true
+		}
+	}
+	for _, lb := range region.LoadBalancers {
+		for _, g := range lb.SecurityGroups {
+			attached[stringValue(g)] = true
+		}
+	}
+
+	for _, sg := range region.SecurityGroups {
+		id := stringValue(sg.GroupID)
+
+		// the default group can't be deleted, so it's no use flagging it.
+		if !attached[id] && stringValue(sg.GroupName) != "default" {
+			add("unattached-group", Low, id, "", "%v (%v) is attached to no instance or ELB", id, stringValue(sg.GroupName))
+		}
+
+		grants := groupGrants(sg)
+		for _, g := range grants {
+			if !g.egress && g.source == Internet && (g.protocol == "tcp" || g.protocol == "all") {
+				var open []string
+				for port, name := range SensitivePorts {
+					if g.from <= port && port <= g.to {
+						open = append(open, fmt.Sprintf("%v %d", name, port))
+					}
+				}
+				sort.Strings(open)
+				if len(open) > 0 {
+					add("open-sensitive-port", High, id, g.String(), "%v opens %v to the internet", id, strings.Join(open, ", "))
+				}
+			}
+
+			// members of the group talking to each other on any port is the usual pattern.
+			if !g.egress && g.protocol != "icmp" && g.ports() > BroadPortRange && g.source != id {
+				severity := Medium
+				if g.source == Internet {
+					severity = High
+				}
+				add("broad-port-range", severity, id, g.String(), "%v allows %d ports in from %v", id, g.ports(), g.source)
+			}
+
+			if strings.HasPrefix(g.source, "sg-") && groups[g.source] == nil {
+				switch {
+				case g.owner != "" && g.owner != stringValue(sg.OwnerID):
+					add("unverifiable-group", Low, id, g.String(), "%v references %v of account %v, which isn't in the snapshot", id, g.source, g.owner)
+				case peered[stringValue(sg.VPCID)]:
+					add("unverifiable-group", Low, id, g.String(), "%v references %v, which may be in a peered VPC", id, g.source)
+				default:
+					add("missing-group", Medium, id, g.String(), "%v references %v, which doesn't exist", id, g.source)
+				}
+			}
+		}
+
+		reported := make(map[int]bool)
+		for i, a := range grants {
+			for j := i + 1; j < len(grants); j++ {
+				b := grants[j]
+				switch {
+				case reported[j] || reported[i]:
+				case a == b:
+					reported[j] = true
+					add("duplicate-rule", Low, id, b.String(), "%v grants %v twice", id, b)
+				case a.covers(b):
+					reported[j] = true
+					add("shadowed-rule", Low, id, b.String(), "%v is covered by %v", b, a)
+				case b.covers(a):
+					reported[i] = true
+					add("shadowed-rule", Low, id, a.String(), "%v is covered by %v", a, b)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Check < b.Check
+	})
+
+	return report
+}
+
+// WriteTable writes the findings as aligned columns.
+func (ar *AuditReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "severity\tgroup\tcheck\tmessage")
+	for _, f := range ar.Findings {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", f.Severity, f.Group, f.Check, f.Message)
+	}
+
+	return tw.Flush()
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	Id                   string            `json:"id"`
+	ShortDescription     sarifText         `json:"shortDescription"`
+	DefaultConfiguration map[string]string `json:"defaultConfiguration"`
+}
+
+type sarifResult struct {
+	RuleId    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifText       `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName,omitempty"`
+	Kind               string `json:"kind"`
+}
+
+// WriteSARIF writes the findings as a SARIF 2.1.0 log, the security group and rule as the logical location.
+func (ar *AuditReport) WriteSARIF(w io.Writer) error {
+	run := sarifRun{
+		Tool:    sarifTool{sarifDriver{Name: "awsmap", Version: Version}},
+		Results: make([]sarifResult, 0, len(ar.Findings)),
+	}
+
+	for _, c := range AuditChecks {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			Id:                   c.Id,
+			ShortDescription:     sarifText{c.Description},
+			DefaultConfiguration: map[string]string{"level": c.Severity.sarifLevel()},
+		})
+	}
+
+	for _, f := range ar.Findings {
+		location := sarifLogicalLocation{Name: f.Group, Kind: "resource"}
+		if f.Rule != "" {
+			location.FullyQualifiedName = f.Group + " " + f.Rule
+		}
+
+		run.Results = append(run.Results, sarifResult{
+			RuleId:    f.Check,
+			Level:     f.Severity.sarifLevel(),
+			Message:   sarifText{f.Message},
+			Locations: []sarifLocation{{[]sarifLogicalLocation{location}}},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(sarifLog{"2.1.0", "https://json.schemastore.org/sarif-2.1.0.json", []sarifRun{run}})
+}
+
+// WriteResult writes the findings as a table, json or SARIF.
+func (ar *AuditReport) WriteResult(w io.Writer, format string) error {
+	switch format {
+	case "table", "text":
+		return ar.WriteTable(w)
+	case "json":
+		return json.NewEncoder(w).Encode(ar)
+	case "sarif":
+		return ar.WriteSARIF(w)
+	}
+
+	return UnknownFormat
+}
@@ -0,0 +1,114 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+import . "."
+
+func Test_AuditSecurityGroups_should_flag_each_check(t *testing.T) {
+	region := snapshotFrom(t, `{
+		"SecurityGroups": [
+			{"GroupID": "sg-web", "GroupName": "web", "IPPermissions": [
+				{"IPProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IPRanges": [{"CIDRIP": "0.0.0.0/0"}]},
+				{"IPProtocol": "tcp", "FromPort": 443, "ToPort": 443, "IPRanges": [{"CIDRIP": "10.0.0.0/8"}, {"CIDRIP": "10.0.0.0/8"}]},
+				{"IPProtocol": "tcp", "FromPort": 8000, "ToPort": 8100, "IPRanges": [{"CIDRIP": "10.0.0.0/8"}]},
+				{"IPProtocol": "tcp", "FromPort": 8080, "ToPort": 8080, "IPRanges": [{"CIDRIP": "10.1.0.0/16"}]},
+				{"IPProtocol": "tcp", "FromPort": 5432, "ToPort": 5432, "UserIDGroupPairs": [{"GroupID": "sg-gone"}]}
+			]},
+			{"GroupID": "sg-batch", "GroupName": "batch", "IPPermissions": [
+				{"IPProtocol": "-1", "UserIDGroupPairs": [{"GroupID": "sg-batch"}]},
+				{"IPProtocol": "tcp", "FromPort": 1024, "ToPort": 65535, "IPRanges": [{"CIDRIP": "10.0.0.0/8"}]}
+			]},
+			{"GroupID": "sg-default", "GroupName": "default"}
+		],
+		"Instances": [{"InstanceID": "i-1", "SecurityGroups": [{"GroupID": "sg-web"}]}]
+	}`).Region
+
+	report := AuditSecurityGroups(region)
+
+	got := make(map[string]string)
+	for _, f := range report.Findings {
+		got[f.Group+" "+f.Check] = f.Severity.String() + ": " + f.Message
+	}
+
+	want := map[string]string{
+		"sg-web open-sensitive-port": "high: sg-web opens ssh 22 to the internet",
+		"sg-web duplicate-rule":      "low: sg-web grants ingress tcp 443 10.0.0.0/8 twice",
+		"sg-web shadowed-rule":       "low: ingress tcp 8080 10.1.0.0/16 is covered by ingress tcp 8000-8100 10.0.0.0/8",
+		"sg-web missing-group":       "medium: sg-web references sg-gone, which doesn't exist",
+		"sg-batch broad-port-range":  "medium: sg-batch allows 64512 ports in from 10.0.0.0/8",
+		"sg-batch unattached-group":  "low: sg-batch (batch) is attached to no instance or ELB",
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("len(findings) = %v, want %v: %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%v = %q, want %q", k, got[k], v)
+		}
+	}
+
+	if report.Findings[0].Severity != High || len(report.AtLeast(Medium)) != 3 {
+		t.Errorf("findings = %v, want high first and 3 of at least medium", report.Findings)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteResult(&buf, "sarif"); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	var sarif struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				RuleId string
+				Level  string
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &sarif); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if sarif.Version != "2.1.0" || sarif.Runs[0].Results[0].RuleId != "open-sensitive-port" || sarif.Runs[0].Results[0].Level != "error" {
+		t.Errorf("sarif = %+v, want version 2.1.0 with the open ssh port first as an error", sarif)
+	}
+}
+
+func Test_AuditSecurityGroups_should_not_flag_groups_of_other_accounts_or_peered_vpcs_as_missing(t *testing.T) {
+	region := snapshotFrom(t, `{
+		"SecurityGroups": [
+			{"GroupID": "sg-app", "OwnerID": "111", "VPCID": "vpc-1", "IPPermissions": [
+				{"IPProtocol": "tcp", "FromPort": 80, "ToPort": 80, "UserIDGroupPairs": [{"GroupID": "sg-partner", "UserID": "222"}]},
+				{"IPProtocol": "tcp", "FromPort": 81, "ToPort": 81, "UserIDGroupPairs": [{"GroupID": "sg-gone", "UserID": "111"}]}
+			]},
+			{"GroupID": "sg-peered", "OwnerID": "111", "VPCID": "vpc-2", "IPPermissions": [
+				{"IPProtocol": "tcp", "FromPort": 80, "ToPort": 80, "UserIDGroupPairs": [{"GroupID": "sg-remote"}]}
+			]}
+		],
+		"Routes": [{"RouteTableID": "rtb-2", "VPCID": "vpc-2", "Routes": [{"DestinationCIDRBlock": "10.9.0.0/16", "VPCPeeringConnectionID": "pcx-1"}]}],
+		"Instances": [{"InstanceID": "i-1", "SecurityGroups": [{"GroupID": "sg-app"}, {"GroupID": "sg-peered"}]}]
+	}`).Region
+
+	got := make(map[string]string)
+	for _, f := range AuditSecurityGroups(region).Findings {
+		got[f.Rule] = f.Check + " " + f.Severity.String()
+	}
+
+	want := map[string]string{
+		"ingress tcp 80 sg-partner": "unverifiable-group low",
+		"ingress tcp 81 sg-gone":    "missing-group medium",
+		"ingress tcp 80 sg-remote":  "unverifiable-group low",
+	}
+	if len(got) != len(want) {
+		t.Errorf("findings = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%v = %q, want %q", k, got[k], v)
+		}
+	}
+}
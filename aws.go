@@ -270,21 +270,44 @@ func (me MultiError) HasError() bool {
 
 type callable func(cfg *aws.Config, config *Config, region *AwsRegion) error
 
+type collector struct {
+	name string
+	fn   callable
+}
+
+var collectors = []collector{
+	{"instances", fetchInstances},
+	{"security_groups", fetchSecurityGroups},
+	{"subnets", fetchSubnets},
+	{"elbs", fetchElbs},
+	{"vpcs", fetchVpcs},
+	{"acls", fetchAcls},
+	{"routes", fetchRoutes},
+	{"gateways", fetchGateways},
+}
+
+// CollectorNames lists the collectors fetchRegion runs.
+func CollectorNames() (names []string) {
+	for _, c := range collectors {
+		names = append(names, c.name)
+	}
+
+	return names
+}
+
 func fetchRegion(config *Config) (region *AwsRegion, err error) {
 	cfg := &aws.Config{Region: config.Region}
 	var wg sync.WaitGroup
 	region = &AwsRegion{}
 
-	fns := []callable{fetchInstances, fetchSecurityGroups, fetchSubnets, fetchElbs, fetchVpcs, fetchAcls, fetchRoutes, fetchGateways}
-	errors := make(MultiError, len(fns), len(fns))
+	errors := make(MultiError, len(collectors), len(collectors))
 
-	for i, fn := range fns {
+	for i, c := range collectors {
 		wg.Add(1)
 		go func(fn callable, i int) {
-			err = fn(cfg, config, region)
-			errors[i] = err
+			errors[i] = fn(cfg, config, region)
 			wg.Done()
-		}(fn, i)
+		}(c.fn, i)
 	}
 
 	wg.Wait()
@@ -295,3 +318,15 @@ func fetchRegion(config *Config) (region *AwsRegion, err error) {
 
 	return region, nil
 }
+
+// regionAccount returns the account owning the security groups, the only
+// resource collected that records it.
+func regionAccount(region *AwsRegion) string {
+	for _, sg := range region.SecurityGroups {
+		if sg.OwnerID != nil {
+			return *sg.OwnerID
+		}
+	}
+
+	return ""
+}
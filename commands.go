@@ -0,0 +1,717 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Exit codes shared by the commands, following diff(1): 1 signals a finding, 2 a failure.
+const (
+	ExitOk      = 0
+	ExitFinding = 1
+	ExitError   = 2
+)
+
+var UnknownFormat = errors.New("Unknown output format!")
+
+// command is a subcommand invoked as `awsmap <name> [args]`.
+type command func(config *Config, args []string) int
+
+var commands = map[string]command{
+	"audit":      auditCommand,
+	"can-reach":  canReachCommand,
+	"diff":       diffCommand,
+	"exposure":   exposureCommand,
+	"graph":      graphCommand,
+	"merge":      mergeCommand,
+	"path":       pathCommand,
+	"query":      queryCommand,
+	"redact":     redactCommand,
+	"report":     reportCommand,
+	"resilience": resilienceCommand,
+	"rules":      rulesCommand,
+}
+
+// parseArgs parses flags that may be interleaved with positional arguments,
+// e.g. `awsmap diff old.json new.json -format json`.
+func parseArgs(fs *flag.FlagSet, args []string) (positional []string, err error) {
+	for {
+		err = fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func commandError(name string, err error) int {
+	fmt.Fprintf(os.Stderr, "awsmap %v: %v\n", name, err)
+	return ExitError
+}
+
+// commandView loads the snapshot selected by the global flags, as -serve would.
+func commandView(config *Config) (view *GraphView, err error) {
+	store, err := openStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadView(config, store)
+}
+
+func diffCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "text", "Output format, text or json.")
+	graph := fs.Bool("graph", false, "Compare the built graphs' nodes and edges rather than the resources.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap diff [-format text|json] [-graph] old.json new.json")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(files) != 2 {
+		fs.Usage()
+		return ExitError
+	}
+
+	old, err := loadSnapshot(files[0])
+	if err != nil {
+		return commandError("diff", err)
+	}
+
+	new, err := loadSnapshot(files[1])
+	if err != nil {
+		return commandError("diff", err)
+	}
+
+	if *graph {
+		return graphDiffCommand(config, old, new, *format)
+	}
+
+	d := DiffSnapshots(old, new)
+
+	switch *format {
+	case "text":
+		err = d.WriteText(os.Stdout)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(d)
+	default:
+		err = UnknownFormat
+	}
+
+	if err != nil {
+		return commandError("diff", err)
+	}
+
+	if d.HasDrift() {
+		return ExitFinding
+	}
+
+	return ExitOk
+}
+
+func graphDiffCommand(config *Config, old, new *Snapshot, format string) int {
+	d := DiffGraphs(snapshotView(config, old).Graph, snapshotView(config, new).Graph)
+
+	var err error
+	switch format {
+	case "text":
+		err = d.WriteText(os.Stdout)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(d)
+	default:
+		err = UnknownFormat
+	}
+
+	if err != nil {
+		return commandError("diff", err)
+	}
+
+	if d.HasChanges() {
+		return ExitFinding
+	}
+
+	return ExitOk
+}
+
+// stringList is a comma separated flag value.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s != "" {
+			*sl = append(*sl, s)
+		}
+	}
+
+	return nil
+}
+
+func redactCommand(config *Config, args []string) int {
+	var dropTags, keepTags stringList
+
+	fs := flag.NewFlagSet("redact", flag.ContinueOnError)
+	key := fs.String("key", os.Getenv("AWSMAP_REDACT_KEY"), "Secret the pseudonyms are derived from, defaults to $AWSMAP_REDACT_KEY.")
+	fs.Var(&dropTags, "drop-tags", "Comma separated tag keys to remove.")
+	fs.Var(&keepTags, "keep-tags", "Comma separated tag keys whose values are kept in the clear.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap redact [-key secret] [-drop-tags k1,k2] [-keep-tags k3] in.json out.json")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(files) != 2 {
+		fs.Usage()
+		return ExitError
+	}
+
+	secret := []byte(*key)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return commandError("redact", err)
+		}
+		fmt.Fprintln(os.Stderr, "awsmap redact: no -key given, pseudonyms won't match other redacted snapshots.")
+	}
+
+	snapshot, err := loadSnapshot(files[0])
+	if err != nil {
+		return commandError("redact", err)
+	}
+
+	NewRedactor(secret, dropTags, keepTags).RedactSnapshot(snapshot)
+
+	err = saveSnapshot(files[1], snapshot)
+	if err != nil {
+		return commandError("redact", err)
+	}
+
+	return ExitOk
+}
+
+func mergeCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("o", "merged.json", "File to write the merged snapshot to.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap merge [-o merged.json] a.json b.json ...")
+		fmt.Fprintln(os.Stderr, "The snapshots must be of one region, from any number of accounts.")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(files) < 2 {
+		fs.Usage()
+		return ExitError
+	}
+
+	merged, err := loadMerged(files)
+	if err != nil {
+		return commandError("merge", err)
+	}
+
+	err = saveSnapshot(*out, merged)
+	if err != nil {
+		return commandError("merge", err)
+	}
+
+	return ExitOk
+}
+
+func pathCommand(config *Config, args []string) int {
+	var rels stringList
+
+	fs := flag.NewFlagSet("path", flag.ContinueOnError)
+	all := fs.Bool("all", false, "Print every simple path up to -depth hops rather than the shortest.")
+	depth := fs.Int("depth", 6, "Maximum number of hops, at least 1 with -all.")
+	fs.Var(&rels, "rel", "Comma separated relationships the path may follow.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap [-filename region.json] path [-all] [-depth n] [-rel r1,r2] <from> <to>")
+		fmt.Fprintln(os.Stderr, "from and to are node identities, account/region/type/id, or any unique suffix such as an instance id.")
+		fs.PrintDefaults()
+	}
+
+	ids, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(ids) != 2 {
+		fs.Usage()
+		return ExitError
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("path", err)
+	}
+
+	from, err := view.Graph.Lookup(ids[0])
+	if err != nil {
+		return commandError("path", fmt.Errorf("%v: %v", ids[0], err))
+	}
+
+	to, err := view.Graph.Lookup(ids[1])
+	if err != nil {
+		return commandError("path", fmt.Errorf("%v: %v", ids[1], err))
+	}
+
+	opts := TraversalOptions{MaxDepth: *depth}
+	if len(rels) > 0 {
+		filter := make([]Relationship, 0, len(rels))
+		for _, r := range rels {
+			filter = append(filter, Relationship(r))
+		}
+		opts.Relationships = append(opts.Relationships, IsRelationship(filter...))
+	}
+
+	var paths []Path
+	if *all {
+		paths, err = view.Graph.AllPaths(from.Id, to.Id, *depth, opts)
+	} else {
+		var p Path
+		p, err = view.Graph.ShortestPath(from.Id, to.Id, opts)
+		paths = []Path{p}
+	}
+
+	if err == PathNotFound {
+		fmt.Fprintf(os.Stderr, "awsmap path: no path from %v to %v within %v hops\n", ids[0], ids[1], *depth)
+		return ExitFinding
+	}
+
+	if err != nil {
+		return commandError("path", err)
+	}
+
+	for i, p := range paths {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Println(from.Id)
+		for _, e := range p {
+			fmt.Printf("  -[%v]-> %v\n", e.Relationship, e.To.Id)
+		}
+	}
+
+	return ExitOk
+}
+
+func queryCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	format := fs.String("format", "table", "Output format, table or json.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: awsmap [-filename region.json] query [-format table|json] 'MATCH (e:elb)-[:proxies]->(i:instance) RETURN e, i'`)
+		fs.PrintDefaults()
+	}
+
+	queries, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(queries) != 1 {
+		fs.Usage()
+		return ExitError
+	}
+
+	q, err := ParseQuery(queries[0])
+	if err != nil {
+		return commandError("query", err)
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("query", err)
+	}
+
+	view, err = view.Select(&config.Selection, false)
+	if err != nil {
+		return commandError("query", err)
+	}
+
+	err = q.Run(view.Graph).WriteResult(os.Stdout, *format)
+	if err != nil {
+		return commandError("query", err)
+	}
+
+	return ExitOk
+}
+
+func reportCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	format := fs.String("format", "text", "Output format, text or json.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap [-filename region.json] [-placeholders] report [-format text|json]")
+		fs.PrintDefaults()
+	}
+
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(rest) != 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("report", err)
+	}
+
+	switch *format {
+	case "text":
+		err = view.Report.WriteText(os.Stdout)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(view.Report)
+	default:
+		err = UnknownFormat
+	}
+
+	if err != nil {
+		return commandError("report", err)
+	}
+
+	if view.Report.HasProblems() {
+		return ExitFinding
+	}
+
+	return ExitOk
+}
+
+func graphCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	out := fs.String("o", "graph.json", "File to write the graph to, compressed when ending in .gz or .zst.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap [-filename region.json] [-seed id -hops n] [-select-type t] [-select-prop k=v] graph [-o graph.json]")
+		fs.PrintDefaults()
+	}
+
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(rest) != 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("graph", err)
+	}
+
+	view, err = view.Select(&config.Selection, false)
+	if err != nil {
+		return commandError("graph", err)
+	}
+
+	err = saveGraph(*out, view)
+	if err != nil {
+		return commandError("graph", err)
+	}
+
+	return ExitOk
+}
+
+func exposureCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("exposure", flag.ContinueOnError)
+	format := fs.String("format", "text", "Output format, text or json.")
+	all := fs.Bool("all", false, "Explain the instances and ELBs that aren't reachable too.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap [-filename region.json] [-seed id -hops n] exposure [-format text|json] [-all]")
+		fs.PrintDefaults()
+	}
+
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(rest) != 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("exposure", err)
+	}
+
+	view, err = view.Select(&config.Selection, false)
+	if err != nil {
+		return commandError("exposure", err)
+	}
+
+	report, err := view.Reachability()
+	if err != nil {
+		return commandError("exposure", err)
+	}
+
+	switch *format {
+	case "text":
+		err = report.WriteText(os.Stdout, *all)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(report)
+	default:
+		err = UnknownFormat
+	}
+
+	if err != nil {
+		return commandError("exposure", err)
+	}
+
+	if len(report.Reachable()) > 0 {
+		return ExitFinding
+	}
+
+	return ExitOk
+}
+
+func canReachCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("can-reach", flag.ContinueOnError)
+	port := fs.Int64("port", 0, "Destination port, not needed for icmp.")
+	protocol := fs.String("protocol", "tcp", "Protocol, tcp, udp or icmp.")
+	format := fs.String("format", "text", "Output format, text or json.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap [-filename region.json] can-reach [-protocol tcp|udp|icmp] [-format text|json] -port n from-instance to-instance")
+		fs.PrintDefaults()
+	}
+
+	ids, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(ids) != 2 || (*port == 0 && *protocol != "icmp") {
+		fs.Usage()
+		return ExitError
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("can-reach", err)
+	}
+
+	c, err := view.CanReach(ids[0], ids[1], *protocol, *port)
+	if err != nil {
+		return commandError("can-reach", err)
+	}
+
+	switch *format {
+	case "text":
+		err = c.WriteText(os.Stdout)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(c)
+	default:
+		err = UnknownFormat
+	}
+
+	if err != nil {
+		return commandError("can-reach", err)
+	}
+
+	if !c.Reachable {
+		return ExitFinding
+	}
+
+	return ExitOk
+}
+
+func auditCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	format := fs.String("format", "table", "Output format, table, json or sarif.")
+	failOn := fs.String("fail-on", "low", "Exit with 1 on findings of this severity or worse, low, medium or high.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap [-filename region.json] audit [-format table|json|sarif] [-fail-on low|medium|high]")
+		fs.PrintDefaults()
+	}
+
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(rest) != 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	min, err := ParseSeverity(*failOn)
+	if err != nil {
+		return commandError("audit", err)
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("audit", err)
+	}
+
+	if view.Region == nil {
+		return commandError("audit", NoRegionData)
+	}
+
+	report := AuditSecurityGroups(view.Region)
+	err = report.WriteResult(os.Stdout, *format)
+	if err != nil {
+		return commandError("audit", err)
+	}
+
+	if len(report.AtLeast(min)) > 0 {
+		return ExitFinding
+	}
+
+	return ExitOk
+}
+
+func rulesCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("rules", flag.ContinueOnError)
+	format := fs.String("format", "text", "Output format, text or json.")
+	failOn := fs.String("fail-on", "low", "Exit with 1 on violations of rules of this severity or worse, low, medium or high.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap [-filename region.json] [-rules rules.json] rules [-format text|json] [-fail-on low|medium|high] [rules.json]")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	filename := config.Rules
+	if len(files) == 1 {
+		filename = files[0]
+	}
+
+	if len(files) > 1 || filename == "" {
+		fs.Usage()
+		return ExitError
+	}
+
+	min, err := ParseSeverity(*failOn)
+	if err != nil {
+		return commandError("rules", err)
+	}
+
+	rules, err := LoadRules(filename)
+	if err != nil {
+		return commandError("rules", err)
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("rules", err)
+	}
+
+	view, err = view.Select(&config.Selection, false)
+	if err != nil {
+		return commandError("rules", err)
+	}
+
+	report := rules.Evaluate(view.Graph)
+	switch *format {
+	case "text":
+		err = report.WriteText(os.Stdout)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(report)
+	default:
+		err = UnknownFormat
+	}
+
+	if err != nil {
+		return commandError("rules", err)
+	}
+
+	if report.Violations(min) > 0 {
+		return ExitFinding
+	}
+
+	return ExitOk
+}
+
+func resilienceCommand(config *Config, args []string) int {
+	fs := flag.NewFlagSet("resilience", flag.ContinueOnError)
+	format := fs.String("format", "table", "Output format, table or json.")
+	imbalance := fs.Float64("imbalance", DefaultImbalance, "Flag ELBs whose busiest AZ has this fraction more instances than the quietest.")
+	failOn := fs.String("fail-on", "low", "Exit with 1 on findings of this severity or worse, low, medium or high.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: awsmap [-filename region.json] resilience [-format table|json] [-imbalance 0.5] [-fail-on low|medium|high]")
+		fs.PrintDefaults()
+	}
+
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitError
+	}
+
+	if len(rest) != 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	min, err := ParseSeverity(*failOn)
+	if err != nil {
+		return commandError("resilience", err)
+	}
+
+	view, err := commandView(config)
+	if err != nil {
+		return commandError("resilience", err)
+	}
+
+	selected, err := view.Select(&config.Selection, false)
+	if err != nil {
+		return commandError("resilience", err)
+	}
+
+	report := AnalyzeResilience(view.Graph, *imbalance).Only(selected.Graph)
+	switch *format {
+	case "table", "text":
+		err = report.WriteTable(os.Stdout)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(report)
+	default:
+		err = UnknownFormat
+	}
+
+	if err != nil {
+		return commandError("resilience", err)
+	}
+
+	if len(report.AtLeast(min)) > 0 {
+		return ExitFinding
+	}
+
+	return ExitOk
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+)
+
+/* instance to instance connectivity.
+
+Whether one instance can open a connection to another, worked out from the
+snapshot alone, checking what the traffic meets on its way:
+
+	source security group out -> source network acl out -> routes
+	-> destination network acl in -> destination security group in
+
+and, network ACLs being stateless, the replies on the ephemeral ports back
+through the destination ACL out and the source ACL in. Security groups are
+stateful and let the replies through. Traffic stays within a subnet without
+meeting an ACL, and within a VPC over its local route, anything else needs
+a route over a VPC peering connection both ways.
+*/
+
+var NotAnInstance = errors.New("Not an instance!")
+var UnknownProtocol = errors.New("Unknown protocol, want tcp, udp or icmp!")
+
+// Connectivity is whether one instance can connect to another, with the steps explaining why.
+type Connectivity struct {
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	Protocol  string      `json:"protocol"`
+	Port      int64       `json:"port"`
+	Reachable bool        `json:"reachable"`
+	Steps     Explanation `json:"steps"`
+}
+
+func (c *Connectivity) target() string {
+	if c.Protocol == "icmp" {
+		return "icmp"
+	}
+
+	return fmt.Sprintf("%v %d", c.Protocol, c.Port)
+}
+
+// WriteText writes the answer followed by the steps explaining it.
+func (c *Connectivity) WriteText(w io.Writer) (err error) {
+	verb := "can't reach"
+	if c.Reachable {
+		verb = "can reach"
+	}
+
+	_, err = fmt.Fprintf(w, "%v %v %v on %v\n", c.From, verb, c.To, c.target())
+	if err != nil {
+		return err
+	}
+
+	return c.Steps.WriteText(w)
+}
+
+// containing returns a filter for the CIDR blocks containing ip.
+func containing(ip string) func(cidr string) bool {
+	addr := net.ParseIP(ip)
+	return func(cidr string) bool {
+		_, block, err := net.ParseCIDR(cidr)
+		return err == nil && addr != nil && block.Contains(addr)
+	}
+}
+
+func instanceGroups(i *ec2.Instance) (groups []string) {
+	for _, g := range i.SecurityGroups {
+		groups = append(groups, stringValue(g.GroupID))
+	}
+
+	return groups
+}
+
+// AnalyzeConnectivity works out whether from can connect to to on protocol and port.
+func AnalyzeConnectivity(config *Config, region *AwsRegion, from, to *ec2.Instance, protocol string, port int64) (c *Connectivity) {
+	r := newReachability(config, region)
+	c = &Connectivity{
+		From:     resourceNodeId(config, Instance, from),
+		To:       resourceNodeId(config, Instance, to),
+		Protocol: protocol,
+		Port:     port,
+	}
+
+	ex := &c.Steps
+	fromIp, toIp := stringValue(from.PrivateIPAddress), stringValue(to.PrivateIPAddress)
+	fromGroups, toGroups := instanceGroups(from), instanceGroups(to)
+
+	ok := r.groupRule(ex, "source security group out", fromGroups, true, toIp, toGroups, protocol, port)
+
+	if stringValue(from.SubnetID) == stringValue(to.SubnetID) {
+		ok = ex.step("network acls", true, "both in %v, network acls only filter traffic leaving or entering a subnet", stringValue(from.SubnetID)) && ok
+		ok = ex.step("route", true, "both in %v", stringValue(from.SubnetID)) && ok
+	} else {
+		ok = r.aclRule(ex, "source network acl out", from, true, toIp, protocol, port) && ok
+		ok = r.peerRoute(ex, from, to) && ok
+		ok = r.aclRule(ex, "destination network acl in", to, false, fromIp, protocol, port) && ok
+	}
+
+	ok = r.groupRule(ex, "destination security group in", toGroups, false, fromIp, fromGroups, protocol, port) && ok
+
+	if stringValue(from.SubnetID) != stringValue(to.SubnetID) {
+		ok = r.aclReplies(ex, "destination network acl replies", to, true, fromIp, protocol) && ok
+		ok = r.aclReplies(ex, "source network acl replies", from, false, toIp, protocol) && ok
+	}
+
+	c.Reachable = ok
+
+	return c
+}
+
+// groupRule checks a set of security groups has a rule for protocol and port to or from a peer, given by its ip or its groups.
+func (r *reachability) groupRule(ex *Explanation, check string, groups []string, egress bool, peerIp string, peerGroups []string, protocol string, port int64) bool {
+	direction := "from"
+	if egress {
+		direction = "to"
+	}
+
+	peer := containing(peerIp)
+	for _, id := range groups {
+		sg, ok := r.groups[id]
+		if !ok {
+			continue
+		}
+
+		permissions := sg.IPPermissions
+		if egress {
+			if sg.VPCID == nil {
+				return ex.step(check, true, "%v is an EC2-Classic group, which allows everything out", id)
+			}
+			permissions = sg.IPPermissionsEgress
+		}
+
+		for _, p := range permissions {
+			ports := make(portSet)
+			ports.add(normalizeProtocol(stringValue(p.IPProtocol)), p.FromPort, p.ToPort)
+			if !ports.contains(protocol, port) {
+				continue
+			}
+
+			for _, ip := range p.IPRanges {
+				if peer(stringValue(ip.CIDRIP)) {
+					return ex.step(check, true, "%v allows %v %v %v", id, ports, direction, stringValue(ip.CIDRIP))
+				}
+			}
+
+			for _, ug := range p.UserIDGroupPairs {
+				for _, g := range peerGroups {
+					if stringValue(ug.GroupID) == g {
+						return ex.step(check, true, "%v allows %v %v members of %v", id, ports, direction, g)
+					}
+				}
+			}
+		}
+	}
+
+	return ex.step(check, false, "no rule of %v allows %v %d %v %v or the groups %v",
+		strings.Join(groups, ", "), protocol, port, direction, peerIp, strings.Join(peerGroups, ", "))
+}
+
+// aclRule checks the first rule of an instance's network ACL matching the peer, protocol and port allows it.
+func (r *reachability) aclRule(ex *Explanation, check string, i *ec2.Instance, egress bool, peerIp, protocol string, port int64) bool {
+	subnetId := stringValue(i.SubnetID)
+	acl := r.networkAcl(subnetId, stringValue(i.VPCID))
+	if acl == nil {
+		return ex.step(check, true, "%v has no network acl in the snapshot, assuming the default allow all", subnetId)
+	}
+
+	direction := "in from"
+	if egress {
+		direction = "out to"
+	}
+
+	aclId := stringValue(acl.NetworkACLID)
+	for _, entry := range aclEntries(acl, egress, containing(peerIp)) {
+		if entryPorts(entry).contains(protocol, port) {
+			allow := stringValue(entry.RuleAction) == "allow"
+			verb := "denies"
+			if allow {
+				verb = "allows"
+			}
+			return ex.step(check, allow, "rule %d of %v %v %v %d %v %v", longValue(entry.RuleNumber), aclId, verb, protocol, port, direction, stringValue(entry.CIDRBlock))
+		}
+	}
+
+	return ex.step(check, false, "no rule of %v matches %v %d %v %v, the implicit deny applies", aclId, protocol, port, direction, peerIp)
+}
+
+// aclReplies checks an instance's network ACL lets the replies of a connection through on the ephemeral ports.
+func (r *reachability) aclReplies(ex *Explanation, check string, i *ec2.Instance, egress bool, peerIp, protocol string) bool {
+	subnetId := stringValue(i.SubnetID)
+	acl := r.networkAcl(subnetId, stringValue(i.VPCID))
+	if acl == nil {
+		return ex.step(check, true, "%v has no network acl in the snapshot, assuming the default allow all", subnetId)
+	}
+
+	direction := "in from"
+	if egress {
+		direction = "out to"
+	}
+
+	allowed := aclPorts(acl, egress, containing(peerIp))
+	if repliesAllowed(allowed, protocol) {
+		return ex.step(check, true, "%v allows %v %d-%d %v %v", stringValue(acl.NetworkACLID), protocol, EphemeralFrom, EphemeralTo, direction, peerIp)
+	}
+
+	return ex.step(check, false, "%v only allows %v %v %v, replies need %v %d-%d", stringValue(acl.NetworkACLID), allowed, direction, peerIp, protocol, EphemeralFrom, EphemeralTo)
+}
+
+// peerRoute checks the routes between the subnets of two instances.
+func (r *reachability) peerRoute(ex *Explanation, from, to *ec2.Instance) bool {
+	fromVpc, toVpc := stringValue(from.VPCID), stringValue(to.VPCID)
+	if fromVpc == toVpc {
+		return ex.step("route", true, "the local route of %v connects its subnets", fromVpc)
+	}
+
+	there := r.peeringRoute(ex, "route there", from, to)
+	back := r.peeringRoute(ex, "route back", to, from)
+
+	return there && back
+}
+
+// peeringRoute checks the most specific route from the subnet of from to the address of to is over a VPC peering connection.
+func (r *reachability) peeringRoute(ex *Explanation, check string, from, to *ec2.Instance) bool {
+	subnetId, toIp := stringValue(from.SubnetID), stringValue(to.PrivateIPAddress)
+	rt := r.routeTable(subnetId, stringValue(from.VPCID))
+	if rt == nil {
+		return ex.step(check, false, "%v has no route table", subnetId)
+	}
+
+	var best *ec2.Route
+	bestPrefix := -1
+	addr := net.ParseIP(toIp)
+	for _, route := range rt.Routes {
+		_, block, err := net.ParseCIDR(stringValue(route.DestinationCIDRBlock))
+		if err != nil || addr == nil || !block.Contains(addr) {
+			continue
+		}
+
+		if prefix, _ := block.Mask.Size(); prefix > bestPrefix {
+			best, bestPrefix = route, prefix
+		}
+	}
+
+	rtId := stringValue(rt.RouteTableID)
+	if best == nil {
+		return ex.step(check, false, "%v of %v has no route to %v", rtId, subnetId, toIp)
+	}
+
+	destination := stringValue(best.DestinationCIDRBlock)
+	pcx := stringValue(best.VPCPeeringConnectionID)
+	if pcx == "" || stringValue(best.State) == "blackhole" {
+		target := stringValue(firstString(best.VPCPeeringConnectionID, best.GatewayID, best.InstanceID, best.NetworkInterfaceID))
+		return ex.step(check, false, "%v of %v sends %v to %v, not over a vpc peering connection", rtId, subnetId, destination, target)
+	}
+
+	return ex.step(check, true, "%v of %v sends %v over %v", rtId, subnetId, destination, pcx)
+}
+
+// instanceValue looks up the instance with the given id, which may be a short id.
+func instanceValue(g *Graph, id string) (i *ec2.Instance, err error) {
+	n, err := g.Lookup(id)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", id, err)
+	}
+
+	i, ok := n.Value.(*ec2.Instance)
+	if n.Type != Instance || !ok {
+		return nil, fmt.Errorf("%v: %v", id, NotAnInstance)
+	}
+
+	return i, nil
+}
+
+// CanReach analyzes whether the instance from can connect to the instance to on protocol and port.
+func (view *GraphView) CanReach(from, to, protocol string, port int64) (c *Connectivity, err error) {
+	if view.Region == nil {
+		return nil, NoRegionData
+	}
+
+	p := normalizeProtocol(protocol)
+	if p == "" || p == "all" {
+		return nil, UnknownProtocol
+	}
+
+	src, err := instanceValue(view.Graph, from)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := instanceValue(view.Graph, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return AnalyzeConnectivity(view.Config, view.Region, src, dst, p, port), nil
+}
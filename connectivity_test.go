@@ -0,0 +1,112 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+import . "."
+
+const connectivityRegion = `{
+	"Vpcs": [{"VPCID": "vpc-1", "CIDRBlock": "10.0.0.0/16"}, {"VPCID": "vpc-2", "CIDRBlock": "10.1.0.0/16"}],
+	"Subnets": [
+		{"SubnetID": "subnet-app", "VPCID": "vpc-1", "AvailabilityZone": "eu-west-1a", "CIDRBlock": "10.0.1.0/24"},
+		{"SubnetID": "subnet-db", "VPCID": "vpc-1", "AvailabilityZone": "eu-west-1a", "CIDRBlock": "10.0.2.0/24"},
+		{"SubnetID": "subnet-other", "VPCID": "vpc-2", "AvailabilityZone": "eu-west-1a", "CIDRBlock": "10.1.1.0/24"}
+	],
+	"Routes": [
+		{"RouteTableID": "rtb-1", "VPCID": "vpc-1", "Associations": [{"Main": true}], "Routes": [{"DestinationCIDRBlock": "10.0.0.0/16", "GatewayID": "local"}]},
+		{"RouteTableID": "rtb-2", "VPCID": "vpc-2", "Associations": [{"Main": true}], "Routes": [{"DestinationCIDRBlock": "10.1.0.0/16", "GatewayID": "local"}]}
+	],
+	"Acls": [
+		{"NetworkACLID": "acl-1", "VPCID": "vpc-1", "IsDefault": true, "Entries": [
+			{"RuleNumber": 100, "Protocol": "-1", "RuleAction": "allow", "Egress": false, "CIDRBlock": "0.0.0.0/0"},
+			{"RuleNumber": 100, "Protocol": "-1", "RuleAction": "allow", "Egress": true, "CIDRBlock": "0.0.0.0/0"}
+		]},
+		{"NetworkACLID": "acl-db", "VPCID": "vpc-1", "Associations": [{"SubnetID": "subnet-db"}], "Entries": [
+			{"RuleNumber": 100, "Protocol": "6", "RuleAction": "allow", "Egress": false, "CIDRBlock": "10.0.1.0/24", "PortRange": {"From": 5432, "To": 5432}},
+			{"RuleNumber": 100, "Protocol": "6", "RuleAction": "allow", "Egress": true, "CIDRBlock": "10.0.0.0/16", "PortRange": {"From": 1024, "To": 65535}}
+		]}
+	],
+	"SecurityGroups": [
+		{"GroupID": "sg-app", "VPCID": "vpc-1", "IPPermissionsEgress": [{"IPProtocol": "-1", "IPRanges": [{"CIDRIP": "0.0.0.0/0"}]}]},
+		{"GroupID": "sg-db", "VPCID": "vpc-1", "IPPermissions": [{"IPProtocol": "tcp", "FromPort": 5432, "ToPort": 5432, "UserIDGroupPairs": [{"GroupID": "sg-app"}]}]}
+	],
+	"Instances": [
+		{"InstanceID": "i-app", "SubnetID": "subnet-app", "VPCID": "vpc-1", "PrivateIPAddress": "10.0.1.10", "SecurityGroups": [{"GroupID": "sg-app"}]},
+		{"InstanceID": "i-db", "SubnetID": "subnet-db", "VPCID": "vpc-1", "PrivateIPAddress": "10.0.2.10", "SecurityGroups": [{"GroupID": "sg-db"}]},
+		{"InstanceID": "i-other", "SubnetID": "subnet-other", "VPCID": "vpc-2", "PrivateIPAddress": "10.1.1.10", "SecurityGroups": [{"GroupID": "sg-app"}]}
+	]
+}`
+
+func Test_GraphView_CanReach_should_explain_each_hop(t *testing.T) {
+	config := &Config{Region: "eu-west-1"}
+	region := snapshotFrom(t, connectivityRegion).Region
+	view := &GraphView{Graph: BuildGraph(config, region), Config: config, Region: region}
+
+	for _, tc := range []struct {
+		from, to  string
+		port      int64
+		reachable bool
+		step      string
+	}{
+		{"i-app", "i-db", 5432, true, "+ destination security group in: sg-db allows tcp 5432 from members of sg-app"},
+		{"i-app", "i-db", 5432, true, "+ destination network acl in: rule 100 of acl-db allows tcp 5432 in from 10.0.1.0/24"},
+		{"i-app", "i-db", 22, false, "- destination network acl in: no rule of acl-db matches tcp 22 in from 10.0.1.10, the implicit deny applies"},
+		{"i-app", "i-db", 22, false, "- destination security group in: no rule of sg-db allows tcp 22 from 10.0.1.10 or the groups sg-app"},
+		{"i-other", "i-db", 5432, false, "- route there: rtb-2 of subnet-other has no route to 10.0.2.10"},
+	} {
+		c, err := view.CanReach(tc.from, tc.to, "tcp", tc.port)
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+
+		var buf bytes.Buffer
+		c.WriteText(&buf)
+		if c.Reachable != tc.reachable || !strings.Contains(buf.String(), tc.step) {
+			t.Errorf("%v to %v on %d: reachable = %v, want %v with %q:\n%v", tc.from, tc.to, tc.port, c.Reachable, tc.reachable, tc.step, buf.String())
+		}
+	}
+
+	if _, err := view.CanReach("subnet-app", "i-db", "tcp", 5432); err == nil || !strings.Contains(err.Error(), NotAnInstance.Error()) {
+		t.Fatalf("err = %v, want NotAnInstance", err)
+	}
+}
+
+func Test_GraphView_CanReach_should_cross_a_peering_connection_both_ways(t *testing.T) {
+	config := &Config{Region: "eu-west-1"}
+	peered := strings.NewReplacer(
+		`"Routes": [{"DestinationCIDRBlock": "10.0.0.0/16", "GatewayID": "local"}]`,
+		`"Routes": [{"DestinationCIDRBlock": "10.0.0.0/16", "GatewayID": "local"}, {"DestinationCIDRBlock": "10.1.0.0/16", "VPCPeeringConnectionID": "pcx-1"}]`,
+		`"Routes": [{"DestinationCIDRBlock": "10.1.0.0/16", "GatewayID": "local"}]`,
+		`"Routes": [{"DestinationCIDRBlock": "10.1.0.0/16", "GatewayID": "local"}, {"DestinationCIDRBlock": "10.0.0.0/16", "VPCPeeringConnectionID": "pcx-1"}]`,
+		`{"GroupID": "sg-app", "VPCID": "vpc-1", `,
+		`{"GroupID": "sg-app", "VPCID": "vpc-1", "IPPermissions": [{"IPProtocol": "tcp", "FromPort": 80, "ToPort": 80, "IPRanges": [{"CIDRIP": "10.0.0.0/8"}]}], `,
+	).Replace(connectivityRegion)
+	region := snapshotFrom(t, peered).Region
+	view := &GraphView{Graph: BuildGraph(config, region), Config: config, Region: region}
+
+	for _, tc := range []struct {
+		from, to string
+		steps    []string
+	}{
+		{"i-app", "i-other", []string{"+ route there: rtb-1 of subnet-app sends 10.1.0.0/16 over pcx-1", "+ route back: rtb-2 of subnet-other sends 10.0.0.0/16 over pcx-1"}},
+		{"i-other", "i-app", []string{"+ route there: rtb-2 of subnet-other sends 10.0.0.0/16 over pcx-1", "+ route back: rtb-1 of subnet-app sends 10.1.0.0/16 over pcx-1"}},
+	} {
+		c, err := view.CanReach(tc.from, tc.to, "tcp", 80)
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+
+		var buf bytes.Buffer
+		c.WriteText(&buf)
+		if !c.Reachable {
+			t.Errorf("%v to %v: reachable = false, want true:\n%v", tc.from, tc.to, buf.String())
+		}
+		for _, step := range tc.steps {
+			if !strings.Contains(buf.String(), step) {
+				t.Errorf("%v to %v: explanation missing %q:\n%v", tc.from, tc.to, step, buf.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
+
+// ResourceChange lists the facts about a resource that differ between two snapshots.
+type ResourceChange struct {
+	Id      string   `json:"id"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ResourceDiff holds the changes for a single resource type.
+type ResourceDiff struct {
+	Type     string            `json:"type"`
+	Added    []string          `json:"added,omitempty"`
+	Removed  []string          `json:"removed,omitempty"`
+	Modified []*ResourceChange `json:"modified,omitempty"`
+}
+
+// SnapshotDiff is the result of comparing two snapshots resource by resource.
+type SnapshotDiff struct {
+	Old       SnapshotMetadata `json:"old"`
+	New       SnapshotMetadata `json:"new"`
+	Resources []*ResourceDiff  `json:"resources"`
+}
+
+// HasDrift reports whether anything was added, removed or modified.
+func (d *SnapshotDiff) HasDrift() bool {
+	for _, rd := range d.Resources {
+		if len(rd.Added)+len(rd.Removed)+len(rd.Modified) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resourceFacts maps a resource id to the facts describing it, e.g. "instance=i-123".
+type resourceFacts map[string][]string
+
+type factsFunc func(region *AwsRegion) resourceFacts
+
+var diffedResources = []struct {
+	name  string
+	facts factsFunc
+}{
+	{"vpcs", vpcFacts},
+	{"subnets", subnetFacts},
+	{"instances", instanceFacts},
+	{"elbs", elbFacts},
+	{"security_groups", securityGroupFacts},
+	{"acls", aclFacts},
+	{"routes", routeFacts},
+	{"gateways", gatewayFacts},
+}
+
+// DiffSnapshots compares old with new for every collected resource type.
+func DiffSnapshots(old, new *Snapshot) (d *SnapshotDiff) {
+	d = &SnapshotDiff{
+		Old: old.Metadata,
+		New: new.Metadata,
+	}
+
+	for _, r := range diffedResources {
+		d.Resources = append(d.Resources, diffFacts(r.name, r.facts(old.Region), r.facts(new.Region)))
+	}
+
+	return d
+}
+
+func diffFacts(name string, old, new resourceFacts) (rd *ResourceDiff) {
+	rd = &ResourceDiff{Type: name}
+
+	for _, id := range sortedKeys(old) {
+		if _, ok := new[id]; !ok {
+			rd.Removed = append(rd.Removed, id)
+		}
+	}
+
+	for _, id := range sortedKeys(new) {
+		oldFacts, ok := old[id]
+		if !ok {
+			rd.Added = append(rd.Added, id)
+			continue
+		}
+
+		added, removed := diffStrings(oldFacts, new[id])
+		if len(added)+len(removed) > 0 {
+			rd.Modified = append(rd.Modified, &ResourceChange{Id: id, Added: added, Removed: removed})
+		}
+	}
+
+	return rd
+}
+
+// diffStrings returns the elements only in b and the elements only in a.
+func diffStrings(a, b []string) (added, removed []string) {
+	inA := make(map[string]bool, len(a))
+	for _, s := range a {
+		inA[s] = true
+	}
+
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+		if !inA[s] {
+			added = append(added, s)
+		}
+	}
+
+	for _, s := range a {
+		if !inB[s] {
+			removed = append(removed, s)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+func sortedKeys(rf resourceFacts) (keys []string) {
+	for k := range rf {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// WriteText writes the diff in a human readable form, one resource per line.
+func (d *SnapshotDiff) WriteText(w io.Writer) (err error) {
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("--- %v %v\n+++ %v %v\n", d.Old.Source, d.Old.CollectedAt, d.New.Source, d.New.CollectedAt)
+
+	for _, rd := range d.Resources {
+		if len(rd.Added)+len(rd.Removed)+len(rd.Modified) == 0 {
+			continue
+		}
+
+		printf("%v:\n", rd.Type)
+		for _, id := range rd.Added {
+			printf("  + %v\n", id)
+		}
+
+		for _, id := range rd.Removed {
+			printf("  - %v\n", id)
+		}
+
+		for _, rc := range rd.Modified {
+			printf("  ~ %v\n", rc.Id)
+			for _, f := range rc.Removed {
+				printf("      - %v\n", f)
+			}
+			for _, f := range rc.Added {
+				printf("      + %v\n", f)
+			}
+		}
+	}
+
+	if !d.HasDrift() {
+		printf("no changes\n")
+	}
+
+	return err
+}
+
+func fact(name string, v *string) string {
+	return name + "=" + stringValue(v)
+}
+
+func tagFacts(tags []*ec2.Tag) (facts []string) {
+	for _, t := range tags {
+		facts = append(facts, "tag:"+stringValue(t.Key)+"="+stringValue(t.Value))
+	}
+
+	return facts
+}
+
+func vpcFacts(region *AwsRegion) resourceFacts {
+	rf := make(resourceFacts)
+	for _, vpc := range region.Vpcs {
+		rf[stringValue(vpc.VPCID)] = append([]string{
+			fact("cidr", vpc.CIDRBlock),
+			fact("state", vpc.State),
+		}, tagFacts(vpc.Tags)...)
+	}
+
+	return rf
+}
+
+func subnetFacts(region *AwsRegion) resourceFacts {
+	rf := make(resourceFacts)
+	for _, sn := range region.Subnets {
+		rf[stringValue(sn.SubnetID)] = append([]string{
+			fact("cidr", sn.CIDRBlock),
+			fact("az", sn.AvailabilityZone),
+			fact("vpc", sn.VPCID),
+			fact("state", sn.State),
+		}, tagFacts(sn.Tags)...)
+	}
+
+	return rf
+}
+
+func instanceFacts(region *AwsRegion) resourceFacts {
+	rf := make(resourceFacts)
+	for _, i := range region.Instances {
+		facts := []string{
+			fact("type", i.InstanceType),
+			fact("image", i.ImageID),
+			fact("subnet", i.SubnetID),
+			fact("vpc", i.VPCID),
+			fact("private_ip", i.PrivateIPAddress),
+			fact("public_ip", i.PublicIPAddress),
+		}
+
+		if i.State != nil {
+			facts = append(facts, fact("state", i.State.Name))
+		}
+
+		if i.Placement != nil {
+			facts = append(facts, fact("az", i.Placement.AvailabilityZone))
+		}
+
+		for _, sg := range i.SecurityGroups {
+			facts = append(facts, fact("security_group", sg.GroupID))
+		}
+
+		rf[stringValue(i.InstanceID)] = append(facts, tagFacts(i.Tags)...)
+	}
+
+	return rf
+}
+
+func elbFacts(region *AwsRegion) resourceFacts {
+	rf := make(resourceFacts)
+	for _, lb := range region.LoadBalancers {
+		facts := []string{
+			fact("dns", lb.DNSName),
+			fact("scheme", lb.Scheme),
+		}
+
+		for _, i := range lb.Instances {
+			facts = append(facts, fact("instance", i.InstanceID))
+		}
+
+		for _, s := range lb.Subnets {
+			facts = append(facts, fact("subnet", s))
+		}
+
+		for _, sg := range lb.SecurityGroups {
+			facts = append(facts, fact("security_group", sg))
+		}
+
+		for _, ld := range lb.ListenerDescriptions {
+			facts = append(facts, "listener="+formatListener(ld.Listener))
+		}
+
+		rf[stringValue(lb.LoadBalancerName)] = facts
+	}
+
+	return rf
+}
+
+func formatListener(l *elb.Listener) string {
+	if l == nil {
+		return ""
+	}
+
+	return formatLong(l.LoadBalancerPort) + "/" + stringValue(l.Protocol) + "->" +
+		formatLong(l.InstancePort) + "/" + stringValue(l.InstanceProtocol)
+}
+
+func securityGroupFacts(region *AwsRegion) resourceFacts {
+	rf := make(resourceFacts)
+	for _, sg := range region.SecurityGroups {
+		facts := []string{fact("name", sg.GroupName)}
+
+		for _, p := range sg.IPPermissions {
+			for _, r := range formatPermission(p) {
+				facts = append(facts, "ingress="+r)
+			}
+		}
+
+		for _, p := range sg.IPPermissionsEgress {
+			for _, r := range formatPermission(p) {
+				facts = append(facts, "egress="+r)
+			}
+		}
+
+		rf[stringValue(sg.GroupID)] = append(facts, tagFacts(sg.Tags)...)
+	}
+
+	return rf
+}
+
+// formatPermission renders one line per source of the permission, e.g. "tcp 22-22 0.0.0.0/0".
+func formatPermission(p *ec2.IPPermission) (rules []string) {
+	proto := stringValue(p.IPProtocol)
+	if proto == "-1" {
+		proto = "all"
+	}
+
+	ports := "all"
+	if p.FromPort != nil {
+		ports = formatLong(p.FromPort) + "-" + formatLong(p.ToPort)
+	}
+
+	for _, ip := range p.IPRanges {
+		rules = append(rules, proto+" "+ports+" "+stringValue(ip.CIDRIP))
+	}
+
+	for _, ug := range p.UserIDGroupPairs {
+		rules = append(rules, proto+" "+ports+" "+stringValue(ug.GroupID))
+	}
+
+	return rules
+}
+
+func aclFacts(region *AwsRegion) resourceFacts {
+	rf := make(resourceFacts)
+	for _, acl := range region.Acls {
+		facts := []string{fact("vpc", acl.VPCID)}
+
+		for _, e := range acl.Entries {
+			direction := "ingress"
+			if e.Egress != nil && *e.Egress {
+				direction = "egress"
+			}
+
+			ports := "all"
+			if e.PortRange != nil {
+				ports = formatLong(e.PortRange.From) + "-" + formatLong(e.PortRange.To)
+			}
+
+			facts = append(facts, direction+"="+strings.Join([]string{
+				formatLong(e.RuleNumber),
+				stringValue(e.RuleAction),
+				stringValue(e.Protocol),
+				ports,
+				stringValue(e.CIDRBlock),
+			}, " "))
+		}
+
+		for _, a := range acl.Associations {
+			facts = append(facts, fact("subnet", a.SubnetID))
+		}
+
+		rf[stringValue(acl.NetworkACLID)] = facts
+	}
+
+	return rf
+}
+
+func routeFacts(region *AwsRegion) resourceFacts {
+	rf := make(resourceFacts)
+	for _, rt := range region.Routes {
+		facts := []string{fact("vpc", rt.VPCID)}
+
+		for _, r := range rt.Routes {
+			target := firstString(r.GatewayID, r.InstanceID, r.NetworkInterfaceID, r.VPCPeeringConnectionID)
+			facts = append(facts, "route="+stringValue(r.DestinationCIDRBlock)+"->"+stringValue(target))
+		}
+
+		for _, a := range rt.Associations {
+			if a.Main != nil && *a.Main {
+				facts = append(facts, "main=true")
+			} else {
+				facts = append(facts, fact("subnet", a.SubnetID))
+			}
+		}
+
+		rf[stringValue(rt.RouteTableID)] = facts
+	}
+
+	return rf
+}
+
+func gatewayFacts(region *AwsRegion) resourceFacts {
+	rf := make(resourceFacts)
+	for _, igw := range region.Gateways {
+		var facts []string
+		for _, a := range igw.Attachments {
+			facts = append(facts, fact("vpc", a.VPCID))
+		}
+
+		rf[stringValue(igw.InternetGatewayID)] = facts
+	}
+
+	return rf
+}
+
+func formatLong(l *int64) string {
+	if l == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(*l, 10)
+}
@@ -0,0 +1,113 @@
+package main_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+)
+import . "."
+
+func snapshotFrom(t *testing.T, js string) *Snapshot {
+	s, err := ReadSnapshot(strings.NewReader(js))
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	return s
+}
+
+func Test_DiffSnapshots_should_report_no_drift_for_identical_snapshots(t *testing.T) {
+	old := snapshotFrom(t, `{"Instances": [{"InstanceID": "i-1"}]}`)
+	new := snapshotFrom(t, `{"Instances": [{"InstanceID": "i-1"}]}`)
+
+	if d := DiffSnapshots(old, new); d.HasDrift() {
+		t.Fatalf("d.HasDrift() = true, want false")
+	}
+}
+
+func Test_DiffSnapshots_should_report_added_removed_and_modified_resources(t *testing.T) {
+	old := snapshotFrom(t, `{
+		"Instances": [{"InstanceID": "i-1"}, {"InstanceID": "i-2"}],
+		"LoadBalancers": [{"LoadBalancerName": "web", "Instances": [{"InstanceID": "i-1"}, {"InstanceID": "i-2"}]}],
+		"SecurityGroups": [{"GroupID": "sg-1", "IPPermissions": [{"IPProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IPRanges": [{"CIDRIP": "10.0.0.0/8"}]}]}]
+	}`)
+	new := snapshotFrom(t, `{
+		"Instances": [{"InstanceID": "i-1"}, {"InstanceID": "i-3"}],
+		"LoadBalancers": [{"LoadBalancerName": "web", "Instances": [{"InstanceID": "i-1"}, {"InstanceID": "i-3"}]}],
+		"SecurityGroups": [{"GroupID": "sg-1", "IPPermissions": [{"IPProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IPRanges": [{"CIDRIP": "0.0.0.0/0"}]}]}]
+	}`)
+
+	d := DiffSnapshots(old, new)
+	if !d.HasDrift() {
+		t.Fatal("d.HasDrift() = false, want true")
+	}
+
+	byType := make(map[string]*ResourceDiff)
+	for _, rd := range d.Resources {
+		byType[rd.Type] = rd
+	}
+
+	instances := byType["instances"]
+	if strings.Join(instances.Added, ",") != "i-3" || strings.Join(instances.Removed, ",") != "i-2" {
+		t.Fatalf("instances = %+v, want i-3 added and i-2 removed", instances)
+	}
+
+	elbs := byType["elbs"]
+	if len(elbs.Modified) != 1 || strings.Join(elbs.Modified[0].Added, ",") != "instance=i-3" {
+		t.Fatalf("elbs.Modified = %+v, want instance=i-3 added", elbs.Modified)
+	}
+
+	sgs := byType["security_groups"]
+	if len(sgs.Modified) != 1 || strings.Join(sgs.Modified[0].Removed, ",") != "ingress=tcp 22-22 10.0.0.0/8" {
+		t.Fatalf("security_groups.Modified = %+v, want the 10.0.0.0/8 rule removed", sgs.Modified)
+	}
+}
+
+func Test_DiffGraphs_should_report_relationship_changes_once(t *testing.T) {
+	config := &Config{Region: "eu-west-1"}
+	oldRegion := syntheticRegion(1, 1, 2)
+	oldRegion.SecurityGroups = []*ec2.SecurityGroup{{GroupID: aws.String("sg-app")}, {GroupID: aws.String("sg-db")}}
+	old := BuildGraph(config, oldRegion)
+
+	region := syntheticRegion(1, 1, 3)
+	region.LoadBalancers[0].Instances = region.LoadBalancers[0].Instances[1:]
+	region.Subnets[0].State = aws.String("available")
+	region.SecurityGroups = []*ec2.SecurityGroup{{GroupID: aws.String("sg-app")}, {GroupID: aws.String("sg-db"), IPPermissions: []*ec2.IPPermission{
+		{IPProtocol: aws.String("tcp"), FromPort: aws.Long(5432), ToPort: aws.Long(5432), UserIDGroupPairs: []*ec2.UserIDGroupPair{{GroupID: aws.String("sg-app")}}},
+	}}}
+	new := BuildGraph(config, region)
+
+	d := DiffGraphs(old, new)
+
+	if len(d.AddedNodes) != 1 || d.AddedNodes[0].Id != "eu-west-1/instance/i-0-0-2" || len(d.RemovedNodes) != 0 {
+		t.Errorf("nodes added %+v, removed %+v", d.AddedNodes, d.RemovedNodes)
+	}
+
+	// sg-db's value changed, but a group reference isn't a property.
+	if len(d.ModifiedNodes) != 1 || d.ModifiedNodes[0].Added[0] != "state=available" {
+		t.Errorf("modified = %+v, want the subnet state", d.ModifiedNodes)
+	}
+
+	removed := "(eu-west-1/elb/elb-0-0) -[proxies]-> (eu-west-1/instance/i-0-0-0)"
+	if len(d.RemovedEdges) != 1 || d.RemovedEdges[0].String() != removed {
+		t.Errorf("removed edges = %v, want %v", d.RemovedEdges, removed)
+	}
+
+	// the new instance joins the subnet and the elb, and sg-db starts
+	// referencing sg-app, without their inverses.
+	added := []string{
+		"(eu-west-1/elb/elb-0-0) -[proxies]-> (eu-west-1/instance/i-0-0-2)",
+		"(eu-west-1/sg/sg-db) -[references_group]-> (eu-west-1/sg/sg-app)",
+		"(eu-west-1/subnet/subnet-0-0) -[ip_allocated_to_instance]-> (eu-west-1/instance/i-0-0-2)",
+	}
+	if fmt.Sprint(d.AddedEdges) != fmt.Sprint(added) {
+		t.Errorf("added edges = %v, want %v", d.AddedEdges, added)
+	}
+
+	if DiffGraphs(new, new).HasChanges() {
+		t.Errorf("a graph should not differ from itself")
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+// BuildGraph exposes buildGraph to the external tests and benchmarks.
+var BuildGraph = buildGraph
+
+// GenerateDendogram exposes generateDendogram to the benchmarks.
+var GenerateDendogram = generateDendogram
+
+// BuildGraphReport exposes buildGraphReport to the external tests.
+var BuildGraphReport = buildGraphReport
+
+// SnapshotView exposes snapshotView to the external tests.
+var SnapshotView = snapshotView
@@ -1,6 +1,9 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"strconv"
+)
 
 /* relationship labels.
 
@@ -11,6 +14,7 @@ import "errors"
 	*instance* is a single guest VM which is located in an az and associated with a vpc.
 	*elb* is a logical group of hosts that provide loadbalancing for one or more instances.
 			 An elb is located in an az and associated with a vpc.
+	*sg* is a security group, protecting instances and elbs and referencing the groups its rules allow.
 
   (region) -[hosts]-> (vpc)
   (region) <-[hosted_by]- (vpc)
@@ -33,6 +37,12 @@ import "errors"
   (elb) -[proxies]-> (instance)
   (elb) <-[proxied_by]- (instance)
 
+  (sg) -[protects]-> (instance|elb)
+  (sg) <-[protected_by]- (instance|elb)
+
+  (sg) -[references_group]-> (sg)
+  (sg) <-[group_referenced_by]- (sg)
+
 	===
 
 	(az) -[provisions_elb]-> (elb)
@@ -46,8 +56,11 @@ import "errors"
 
 var NodeNotFound = errors.New("Node not found!")
 var NeighboursNotFound = errors.New("Neighbours not found!")
+var EdgeNotFound = errors.New("Edge not found!")
+var UnknownRelationship = errors.New("Relationship not registered!")
+var InvalidEdge = errors.New("Relationship not allowed between these node types!")
+var UnknownType = errors.New("Node type not known!")
 
-type Identity string
 type Type uint
 type Relationship string
 type NodeRef *Node
@@ -59,27 +72,302 @@ type Node struct {
 	Id    string
 	Type  Type
 	Value interface{}
+
+	// Properties are normalized from Value when the node is added or updated.
+	Properties Properties
+
+	// Sources lists where the node was collected from when snapshots are merged.
+	Sources []string
+
+	// Unresolved marks a placeholder for a resource referenced but missing from the snapshot, its Value is nil.
+	Unresolved bool
+}
+
+const (
+	Region Type = iota
+	Vpc
+	Subnet
+	AvailabilityZone
+	RouteTable
+	InternetGateway
+	Instance
+	LoadBalancer
+	Acl
+	SecurityGroup
+)
+
+// typeNames are the stable names types are written and queried by, never renumber or rename them.
+var typeNames = []string{
+	Region:           "region",
+	Vpc:              "vpc",
+	Subnet:           "subnet",
+	AvailabilityZone: "az",
+	RouteTable:       "route_table",
+	InternetGateway:  "igw",
+	Instance:         "instance",
+	LoadBalancer:     "elb",
+	Acl:              "acl",
+	SecurityGroup:    "sg",
+}
+
+func (t Type) String() string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+
+	return "type(" + strconv.Itoa(int(t)) + ")"
+}
+
+// ParseType returns the type named name.
+func ParseType(name string) (t Type, err error) {
+	for i, n := range typeNames {
+		if n == name {
+			return Type(i), nil
+		}
+	}
+
+	return 0, UnknownType
+}
+
+// MarshalText writes the type by name, in JSON values and map keys alike.
+func (t Type) MarshalText() ([]byte, error) {
+	if int(t) >= len(typeNames) {
+		return nil, UnknownType
+	}
+
+	return []byte(typeNames[t]), nil
+}
+
+// UnmarshalText
+func (t *Type) UnmarshalText(text []byte) (err error) {
+	*t, err = ParseType(string(text))
+	return err
+}
+
+// Relationships as documented above, in inverse pairs.
+const (
+	Hosts                   Relationship = "hosts"
+	HostedBy                Relationship = "hosted_by"
+	Houses                  Relationship = "houses"
+	HousedBy                Relationship = "housed_by"
+	AllocatesNetwork        Relationship = "allocates_network"
+	NetworkAllocatedBy      Relationship = "network_allocated_by"
+	HostsNetwork            Relationship = "hosts_network"
+	NetworkHostedBy         Relationship = "network_hosted_by"
+	IpAllocatedToInstance   Relationship = "ip_allocated_to_instance"
+	InstanceIpAllocatedFrom Relationship = "instance_ip_allocated_from"
+	Homes                   Relationship = "homes"
+	HomedIn                 Relationship = "homed_in"
+	Proxies                 Relationship = "proxies"
+	ProxiedBy               Relationship = "proxied_by"
+	Protects                Relationship = "protects"
+	ProtectedBy             Relationship = "protected_by"
+	ReferencesGroup         Relationship = "references_group"
+	GroupReferencedBy       Relationship = "group_referenced_by"
+)
+
+// RelationshipSpec describes a relationship's inverse and the node types it may join.
+type RelationshipSpec struct {
+	Inverse Relationship
+	From    []Type
+	To      []Type
+
+	// Inverted is set on the relationship registered as the inverse of another.
+	Inverted bool
+}
+
+// Allows reports whether the relationship may join a from node to a to node.
+func (rs *RelationshipSpec) Allows(from, to Type) bool {
+	for i := range rs.From {
+		if rs.From[i] == from && rs.To[i] == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Relationships is the registry of relationships AddEdge accepts.
+var Relationships = make(map[Relationship]*RelationshipSpec)
+
+// RegisterRelationship adds (from) -[rel]-> (to) and (to) -[inverse]-> (from)
+// to the registry. A relationship may be registered for several type pairs.
+func RegisterRelationship(from Type, rel Relationship, to Type, inverse Relationship) {
+	register := func(rel, inverse Relationship, from, to Type, inverted bool) {
+		spec, ok := Relationships[rel]
+		if !ok {
+			spec = &RelationshipSpec{Inverse: inverse, Inverted: inverted}
+			Relationships[rel] = spec
+		}
+		spec.From = append(spec.From, from)
+		spec.To = append(spec.To, to)
+	}
+
+	register(rel, inverse, from, to, false)
+	register(inverse, rel, to, from, true)
+}
+
+func init() {
+	RegisterRelationship(Region, Hosts, Vpc, HostedBy)
+	RegisterRelationship(Region, Houses, AvailabilityZone, HousedBy)
+	RegisterRelationship(Vpc, AllocatesNetwork, Subnet, NetworkAllocatedBy)
+	RegisterRelationship(AvailabilityZone, HostsNetwork, Subnet, NetworkHostedBy)
+	RegisterRelationship(Subnet, IpAllocatedToInstance, Instance, InstanceIpAllocatedFrom)
+	RegisterRelationship(Subnet, Homes, LoadBalancer, HomedIn)
+	RegisterRelationship(LoadBalancer, Proxies, Instance, ProxiedBy)
+	RegisterRelationship(SecurityGroup, Protects, Instance, ProtectedBy)
+	RegisterRelationship(SecurityGroup, Protects, LoadBalancer, ProtectedBy)
+	RegisterRelationship(SecurityGroup, ReferencesGroup, SecurityGroup, GroupReferencedBy)
 }
 
-// EdgeList contains all the relationships between nodes.
+// EdgeList contains all the relationships between nodes. Edges are keyed by
+// the id of the node they start from, with secondary indexes by relationship
+// and by the type of either end for Query. Removing an edge doesn't keep the
+// order of the lists it was in.
 type EdgeList struct {
 	EdgeCount int
 	Edges     map[string]Neighbours
+
+	byRelationship map[Relationship]Neighbours
+	byFromType     map[Type]Neighbours
+	byToType       map[Type]Neighbours
+	incoming       map[string]Neighbours
 }
 
+// The EdgeList indexes, each a slot of Edge.slots.
+const (
+	fromIndex = iota
+	toIndex
+	relationshipIndex
+	fromTypeIndex
+	toTypeIndex
+	indexCount
+)
+
 func (el *EdgeList) Len() int {
 	return el.EdgeCount
 }
 
 // AddNeighbour
-func (el *EdgeList) AddNeighbour(from NodeRef, rel Relationship, to NodeRef) {
+func (el *EdgeList) AddNeighbour(from NodeRef, rel Relationship, to NodeRef) (edge *Edge) {
 	el.EdgeCount++
 	neighbours, ok := el.Edges[from.Id]
 	if !ok {
 		neighbours = make(Neighbours, 0, InitialNeighbourCapacity)
 	}
-	neighbours = append(neighbours, &Edge{From: from, Relationship: rel, To: to})
-	el.Edges[from.Id] = neighbours
+	edge = &Edge{From: from, Relationship: rel, To: to}
+	el.Edges[from.Id] = with(neighbours, edge, fromIndex)
+
+	el.byRelationship[rel] = with(el.byRelationship[rel], edge, relationshipIndex)
+	el.byFromType[from.Type] = with(el.byFromType[from.Type], edge, fromTypeIndex)
+	el.byToType[to.Type] = with(el.byToType[to.Type], edge, toTypeIndex)
+	el.incoming[to.Id] = with(el.incoming[to.Id], edge, toIndex)
+
+	return edge
+}
+
+// with appends e to an index, recording its position in the index's slot.
+func with(list Neighbours, e *Edge, slot int) Neighbours {
+	e.slots[slot] = len(list)
+	return append(list, e)
+}
+
+// without returns an index less e, moving its last edge into e's position.
+func without(list Neighbours, e *Edge, slot int) Neighbours {
+	i, last := e.slots[slot], len(list)-1
+	list[i] = list[last]
+	list[i].slots[slot] = i
+	list[last] = nil
+
+	return list[:last]
+}
+
+// unlink removes e from the edge list and every index.
+func (el *EdgeList) unlink(e *Edge) {
+	el.EdgeCount--
+
+	el.Edges[e.From.Id] = without(el.Edges[e.From.Id], e, fromIndex)
+	if len(el.Edges[e.From.Id]) == 0 {
+		delete(el.Edges, e.From.Id)
+	}
+
+	el.incoming[e.To.Id] = without(el.incoming[e.To.Id], e, toIndex)
+	if len(el.incoming[e.To.Id]) == 0 {
+		delete(el.incoming, e.To.Id)
+	}
+
+	el.byRelationship[e.Relationship] = without(el.byRelationship[e.Relationship], e, relationshipIndex)
+	el.byFromType[e.From.Type] = without(el.byFromType[e.From.Type], e, fromTypeIndex)
+	el.byToType[e.To.Type] = without(el.byToType[e.To.Type], e, toTypeIndex)
+}
+
+func (el *EdgeList) findEdge(from string, rel Relationship, to string) *Edge {
+	for _, e := range el.Edges[from] {
+		if e.Relationship == rel && e.To.Id == to {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// RemoveEdge removes (from) -[rel]-> (to) and, when rel is registered, its inverse.
+func (el *EdgeList) RemoveEdge(from string, rel Relationship, to string) error {
+	e := el.findEdge(from, rel, to)
+	if e == nil {
+		return EdgeNotFound
+	}
+	el.unlink(e)
+
+	if spec, ok := Relationships[rel]; ok {
+		if inverse := el.findEdge(to, spec.Inverse, from); inverse != nil {
+			el.unlink(inverse)
+		}
+	}
+
+	return nil
+}
+
+// RemoveNodeEdges removes every edge starting or ending at node id, returning how many were removed.
+func (el *EdgeList) RemoveNodeEdges(id string) (removed int) {
+	edges := append(Neighbours{}, el.Edges[id]...)
+	edges = append(edges, el.incoming[id]...)
+
+	// a self loop is in both lists.
+	seen := make(map[*Edge]bool, len(edges))
+	for _, e := range edges {
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+
+		el.unlink(e)
+		removed++
+	}
+
+	return removed
+}
+
+// AddEdge adds a registered relationship and its inverse, so both ends can
+// find each other. The node types must match the registry.
+func (el *EdgeList) AddEdge(from NodeRef, rel Relationship, to NodeRef) error {
+	spec, ok := Relationships[rel]
+	if !ok {
+		return UnknownRelationship
+	}
+
+	if from == nil || to == nil {
+		return NodeNotFound
+	}
+
+	if !spec.Allows(from.Type, to.Type) {
+		return InvalidEdge
+	}
+
+	el.AddNeighbour(from, rel, to)
+	el.AddNeighbour(to, spec.Inverse, from)
+
+	return nil
 }
 
 // GetNeighbours
@@ -94,7 +382,8 @@ func (el *EdgeList) GetNeighbours(id string) (n Neighbours, err error) {
 
 type RelationshipFilterFunc func(r *Edge) bool
 
-// this could get expensive on a large graph :O
+// GetNeighboursBy scans every edge, prefer Query when the start node, relationship or types are known.
+// Edges are ordered by SortEdges, ByTypeThenId.
 func (el *EdgeList) GetNeighboursBy(filters ...RelationshipFilterFunc) (n Neighbours) {
 	for _, neighbours := range el.Edges {
 		for _, neighbour := range neighbours {
@@ -112,6 +401,114 @@ func (el *EdgeList) GetNeighboursBy(filters ...RelationshipFilterFunc) (n Neighb
 		}
 	}
 
+	SortEdges(n, ByTypeThenId)
+
+	return n
+}
+
+// EdgeQuery selects edges by start node, relationship and end types, reading
+// from the narrowest index that applies before running any filters.
+type EdgeQuery struct {
+	el *EdgeList
+
+	from         string
+	relationship Relationship
+	fromType     Type
+	toType       Type
+	filters      []RelationshipFilterFunc
+
+	hasFrom, hasRelationship, hasFromType, hasToType bool
+}
+
+// Query starts an edge query, e.g. el.Query().From("subnet-1").ToType(Instance).Edges().
+func (el *EdgeList) Query() *EdgeQuery {
+	return &EdgeQuery{el: el}
+}
+
+// From restricts the query to edges starting at node id.
+func (q *EdgeQuery) From(id string) *EdgeQuery {
+	q.from, q.hasFrom = id, true
+	return q
+}
+
+// Rel restricts the query to a relationship.
+func (q *EdgeQuery) Rel(rel Relationship) *EdgeQuery {
+	q.relationship, q.hasRelationship = rel, true
+	return q
+}
+
+// FromType restricts the query to edges starting at nodes of type t.
+func (q *EdgeQuery) FromType(t Type) *EdgeQuery {
+	q.fromType, q.hasFromType = t, true
+	return q
+}
+
+// ToType restricts the query to edges ending at nodes of type t.
+func (q *EdgeQuery) ToType(t Type) *EdgeQuery {
+	q.toType, q.hasToType = t, true
+	return q
+}
+
+// Where adds filters every returned edge must pass.
+func (q *EdgeQuery) Where(filters ...RelationshipFilterFunc) *EdgeQuery {
+	q.filters = append(q.filters, filters...)
+	return q
+}
+
+// candidates returns the smallest indexed list that can hold every match.
+func (q *EdgeQuery) candidates() (n Neighbours, indexed bool) {
+	pick := func(list Neighbours) {
+		if !indexed || len(list) < len(n) {
+			n, indexed = list, true
+		}
+	}
+
+	if q.hasFrom {
+		pick(q.el.Edges[q.from])
+	}
+	if q.hasRelationship {
+		pick(q.el.byRelationship[q.relationship])
+	}
+	if q.hasFromType {
+		pick(q.el.byFromType[q.fromType])
+	}
+	if q.hasToType {
+		pick(q.el.byToType[q.toType])
+	}
+
+	return n, indexed
+}
+
+func (q *EdgeQuery) matches(e *Edge) bool {
+	if (q.hasFrom && e.From.Id != q.from) ||
+		(q.hasRelationship && e.Relationship != q.relationship) ||
+		(q.hasFromType && e.From.Type != q.fromType) ||
+		(q.hasToType && e.To.Type != q.toType) {
+		return false
+	}
+
+	for _, fn := range q.filters {
+		if !fn(e) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Edges runs the query.
+func (q *EdgeQuery) Edges() (n Neighbours) {
+	candidates, indexed := q.candidates()
+	if !indexed {
+		return q.el.GetNeighboursBy(q.filters...)
+	}
+
+	for _, e := range candidates {
+		if q.matches(e) {
+			n = append(n, e)
+		}
+	}
+
 	return n
 }
 
@@ -130,9 +527,10 @@ type NodeList map[string]NodeRef
 // AddNode
 func (nl NodeList) AddNode(id string, t Type, v interface{}) (n *Node) {
 	n = &Node{
-		Id:    id,
-		Type:  t,
-		Value: v,
+		Id:         id,
+		Type:       t,
+		Value:      v,
+		Properties: propertiesOf(v),
 	}
 
 	nl[id] = n
@@ -149,7 +547,7 @@ func (nl NodeList) GetNode(id string) (n NodeRef, err error) {
 	return n, nil
 }
 
-// GetNodes
+// GetNodes returns the nodes passing every filter, ordered ByTypeThenId.
 func (nl NodeList) GetNodes(filters ...NodeFilterFunc) (nodes []NodeRef) {
 	nodes = make([]NodeRef, 0, 16)
 
@@ -167,9 +565,32 @@ func (nl NodeList) GetNodes(filters ...NodeFilterFunc) (nodes []NodeRef) {
 		}
 	}
 
+	SortNodes(nodes, ByTypeThenId)
+
 	return nodes
 }
 
+// UpdateNodeValue replaces the value of node id, keeping its derived
+// properties. Edges hold the node by reference so they see the new value.
+func (nl NodeList) UpdateNodeValue(id string, v interface{}) error {
+	n, ok := nl[id]
+	if !ok {
+		return NodeNotFound
+	}
+
+	p := propertiesOf(v)
+	for _, key := range derivedProperties {
+		if value, ok := n.Properties[key]; ok {
+			p[key] = value
+		}
+	}
+
+	n.Value = v
+	n.Properties = p
+
+	return nil
+}
+
 // Len
 func (nl NodeList) Len() int {
 	return len(nl)
@@ -180,6 +601,12 @@ type Edge struct {
 	From         NodeRef
 	Relationship Relationship
 	To           NodeRef
+
+	// Attributes optionally describe the edge, they're kept by the graph json.
+	Attributes Properties
+
+	// slots holds the edge's position in each EdgeList index, so removing it is O(1).
+	slots [indexCount]int
 }
 
 // Graph
@@ -188,6 +615,19 @@ type Graph struct {
 	*EdgeList
 }
 
+// RemoveNode removes node id and every edge to or from it, in both directions.
+// Graphs being served are never modified, remove nodes before serving or from a copy.
+func (g *Graph) RemoveNode(id string) error {
+	if _, ok := g.NodeList[id]; !ok {
+		return NodeNotFound
+	}
+
+	delete(g.NodeList, id)
+	g.RemoveNodeEdges(id)
+
+	return nil
+}
+
 // NewGraph
 func NewGraph() (g *Graph) {
 	return &Graph{
@@ -199,7 +639,11 @@ func NewGraph() (g *Graph) {
 // NewEdgeList
 func NewEdgeList() (el *EdgeList) {
 	return &EdgeList{
-		Edges: make(map[string]Neighbours),
+		Edges:          make(map[string]Neighbours),
+		byRelationship: make(map[Relationship]Neighbours),
+		byFromType:     make(map[Type]Neighbours),
+		byToType:       make(map[Type]Neighbours),
+		incoming:       make(map[string]Neighbours),
 	}
 }
 
@@ -1,6 +1,17 @@
 package main_test
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
 import . "."
 
 func Test_new_NodeList_should_be_empty(t *testing.T) {
@@ -95,3 +106,537 @@ func Test_EdgeList_AddNeighbour_should_make_the_relationship_retrievable(t *test
 		t.Fatalf("len(neighbours) = %v, want 1", len(neighbours))
 	}
 }
+
+func Test_EdgeList_AddEdge_should_add_the_inverse_relationship(t *testing.T) {
+	el := NewEdgeList()
+	elb := &Node{Id: "web", Type: LoadBalancer}
+	instance := &Node{Id: "i-123abc", Type: Instance}
+
+	err := el.AddEdge(elb, Proxies, instance)
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if el.Len() != 2 {
+		t.Fatalf("el.Len() = %v, want 2", el.Len())
+	}
+
+	neighbours, _ := el.GetNeighbours(instance.Id)
+	if len(neighbours) != 1 || neighbours[0].Relationship != ProxiedBy || neighbours[0].To != elb {
+		t.Fatalf("neighbours = %v, want instance -[proxied_by]-> elb", neighbours)
+	}
+}
+
+func Test_EdgeList_AddEdge_should_reject_edges_between_the_wrong_types(t *testing.T) {
+	el := NewEdgeList()
+	vpc := &Node{Id: "vpc123", Type: Vpc}
+	instance := &Node{Id: "i-123abc", Type: Instance}
+
+	if err := el.AddEdge(vpc, Proxies, instance); err != InvalidEdge {
+		t.Fatalf("err = %v, want InvalidEdge", err)
+	}
+
+	if err := el.AddEdge(vpc, "member of", instance); err != UnknownRelationship {
+		t.Fatalf("err = %v, want UnknownRelationship", err)
+	}
+
+	if el.Len() != 0 {
+		t.Fatalf("el.Len() = %v, want 0", el.Len())
+	}
+}
+
+func Test_Relationships_should_be_registered_with_their_inverse(t *testing.T) {
+	for rel, spec := range Relationships {
+		inverse, ok := Relationships[spec.Inverse]
+		if !ok || inverse.Inverse != rel {
+			t.Fatalf("%v inverse %v does not map back", rel, spec.Inverse)
+		}
+	}
+}
+
+func Test_EdgeList_Query_should_use_every_constraint(t *testing.T) {
+	el := NewEdgeList()
+	subnet := &Node{Id: "subnet-1", Type: Subnet}
+	other := &Node{Id: "subnet-2", Type: Subnet}
+	elb := &Node{Id: "web", Type: LoadBalancer}
+	instance := &Node{Id: "i-123abc", Type: Instance}
+
+	el.AddEdge(subnet, Homes, elb)
+	el.AddEdge(subnet, IpAllocatedToInstance, instance)
+	el.AddEdge(other, Homes, elb)
+
+	if n := el.Query().From("subnet-1").ToType(LoadBalancer).Edges(); len(n) != 1 || n[0].To != elb {
+		t.Fatalf("edges = %v, want subnet-1 -[homes]-> web", n)
+	}
+
+	if n := el.Query().Rel(Homes).Edges(); len(n) != 2 {
+		t.Fatalf("len(edges) = %v, want 2", len(n))
+	}
+
+	if n := el.Query().FromType(LoadBalancer).Rel(HomedIn).Where(IsToSubnetInVpc("vpc-1")).Edges(); len(n) != 0 {
+		t.Fatalf("len(edges) = %v, want 0", len(n))
+	}
+
+	if n := el.Query().Edges(); len(n) != el.Len() {
+		t.Fatalf("len(edges) = %v, want all %v", len(n), el.Len())
+	}
+}
+
+// Benchmark_generateDendogram doubles the estate each step, ns/node should stay flat.
+func Benchmark_generateDendogram(b *testing.B) {
+	config := &Config{Region: "eu-west-1"}
+
+	for _, vpcs := range []int{1, 2, 4, 8} {
+		graph := BuildGraph(config, syntheticRegion(vpcs, 30, 20))
+
+		b.Run(fmt.Sprintf("nodes=%d", graph.NodeList.Len()), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := GenerateDendogram(config, graph); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/float64(graph.NodeList.Len()), "ns/node")
+		})
+	}
+}
+
+func Test_EdgeList_RemoveEdge_should_remove_the_inverse_relationship(t *testing.T) {
+	el := NewEdgeList()
+	elb := &Node{Id: "web", Type: LoadBalancer}
+	instance := &Node{Id: "i-123abc", Type: Instance}
+	el.AddEdge(elb, Proxies, instance)
+
+	err := el.RemoveEdge(elb.Id, Proxies, instance.Id)
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if el.Len() != 0 {
+		t.Fatalf("el.Len() = %v, want 0", el.Len())
+	}
+
+	if _, err := el.GetNeighbours(instance.Id); err != NeighboursNotFound {
+		t.Fatalf("err = %v, want NeighboursNotFound", err)
+	}
+
+	if n := el.Query().Rel(ProxiedBy).Edges(); len(n) != 0 {
+		t.Fatalf("len(edges) = %v, want the index emptied", len(n))
+	}
+
+	if err := el.RemoveEdge(elb.Id, Proxies, instance.Id); err != EdgeNotFound {
+		t.Fatalf("err = %v, want EdgeNotFound", err)
+	}
+}
+
+func Test_Graph_RemoveNode_should_cascade_to_edges_in_both_directions(t *testing.T) {
+	g := NewGraph()
+	subnet := g.AddNode("subnet-1", Subnet, nil)
+	elb := g.AddNode("web", LoadBalancer, nil)
+	i1 := g.AddNode("i-1", Instance, nil)
+	i2 := g.AddNode("i-2", Instance, nil)
+
+	g.AddEdge(subnet, Homes, elb)
+	g.AddEdge(subnet, IpAllocatedToInstance, i1)
+	g.AddEdge(subnet, IpAllocatedToInstance, i2)
+	g.AddEdge(elb, Proxies, i1)
+	g.AddEdge(elb, Proxies, i2)
+
+	err := g.RemoveNode("i-1")
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if g.NodeList.Len() != 3 {
+		t.Fatalf("g.NodeList.Len() = %v, want 3", g.NodeList.Len())
+	}
+
+	if g.EdgeList.Len() != 6 {
+		t.Fatalf("g.EdgeList.Len() = %v, want 6", g.EdgeList.Len())
+	}
+
+	for _, id := range []string{"subnet-1", "web", "i-2"} {
+		neighbours, _ := g.GetNeighbours(id)
+		for _, e := range neighbours {
+			if e.To.Id == "i-1" {
+				t.Fatalf("%v still has an edge to i-1", id)
+			}
+		}
+	}
+
+	if err := g.RemoveNode("i-1"); err != NodeNotFound {
+		t.Fatalf("err = %v, want NodeNotFound", err)
+	}
+
+	// removals move edges around within the indexes, they must still find every edge.
+	if err := g.RemoveNode("i-2"); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if n := g.Query().ToType(Instance).Edges(); len(n) != 0 || g.EdgeList.Len() != 2 {
+		t.Fatalf("edges to instances = %v, len = %v, want none left of 2", n, g.EdgeList.Len())
+	}
+
+	if n := g.Query().Rel(HomedIn).Edges(); len(n) != 1 || n[0].To.Id != "subnet-1" {
+		t.Fatalf("homed_in edges = %v, want web's", n)
+	}
+}
+
+func Test_NodeList_UpdateNodeValue_should_be_visible_through_edges(t *testing.T) {
+	g := NewGraph()
+	elb := g.AddNode("web", LoadBalancer, "old")
+	instance := g.AddNode("i-1", Instance, nil)
+	g.AddEdge(elb, Proxies, instance)
+
+	if err := g.UpdateNodeValue("web", "new"); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	neighbours, _ := g.GetNeighbours("i-1")
+	if neighbours[0].To.Value != "new" {
+		t.Fatalf("value = %v, want new", neighbours[0].To.Value)
+	}
+
+	if err := g.UpdateNodeValue("absent", nil); err != NodeNotFound {
+		t.Fatalf("err = %v, want NodeNotFound", err)
+	}
+}
+
+// pathGraph is elb -proxies-> i-1, i-2 with both instances in subnet-1.
+func pathGraph() *Graph {
+	g := NewGraph()
+	subnet := g.AddNode("subnet-1", Subnet, nil)
+	elb := g.AddNode("web", LoadBalancer, nil)
+	i1 := g.AddNode("i-1", Instance, nil)
+	i2 := g.AddNode("i-2", Instance, nil)
+
+	g.AddEdge(subnet, Homes, elb)
+	g.AddEdge(subnet, IpAllocatedToInstance, i1)
+	g.AddEdge(subnet, IpAllocatedToInstance, i2)
+	g.AddEdge(elb, Proxies, i1)
+	g.AddEdge(elb, Proxies, i2)
+
+	return g
+}
+
+func Test_Graph_ShortestPath_should_return_the_fewest_hops(t *testing.T) {
+	p, err := pathGraph().ShortestPath("web", "subnet-1", TraversalOptions{})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if p.String() != "(web) -[homed_in]-> (subnet-1)" {
+		t.Fatalf("p = %v, want the direct homed_in edge", p)
+	}
+
+	p, err = pathGraph().ShortestPath("web", "subnet-1", TraversalOptions{Relationships: []RelationshipFilterFunc{IsRelationship(Proxies, InstanceIpAllocatedFrom)}})
+	if err != nil || len(p) != 2 {
+		t.Fatalf("p = %v, want a path through an instance", p)
+	}
+}
+
+func Test_Graph_ShortestPath_should_return_not_found_when_filtered_out(t *testing.T) {
+	_, err := pathGraph().ShortestPath("web", "subnet-1", TraversalOptions{Relationships: []RelationshipFilterFunc{IsRelationship(Proxies)}})
+	if err != PathNotFound {
+		t.Fatalf("err = %v, want PathNotFound", err)
+	}
+}
+
+func Test_Graph_AllPaths_should_return_every_simple_path_within_the_depth(t *testing.T) {
+	paths, err := pathGraph().AllPaths("web", "subnet-1", 2, TraversalOptions{})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	// directly, or via either instance.
+	if len(paths) != 3 {
+		t.Fatalf("len(paths) = %v, want 3: %v", len(paths), paths)
+	}
+	if _, err = pathGraph().AllPaths("web", "subnet-1", 0, TraversalOptions{}); err != UnboundedDepth {
+		t.Fatalf("err = %v, want UnboundedDepth", err)
+	}
+}
+
+func Test_Graph_BFS_should_visit_nearest_first_within_the_depth(t *testing.T) {
+	var visited []string
+	pathGraph().BFS("i-1", TraversalOptions{MaxDepth: 1}, func(n NodeRef, depth int, via *Edge) bool {
+		visited = append(visited, n.Id)
+		return true
+	})
+
+	if len(visited) != 3 || visited[0] != "i-1" {
+		t.Fatalf("visited = %v, want i-1 then its two neighbours", visited)
+	}
+}
+
+func Test_Graph_DFS_should_reach_everything_within_the_depth_of_a_diamond(t *testing.T) {
+	// a -> b -> c -> d, with a shortcut a -> c found after the long way round.
+	g := NewGraph()
+	a := g.AddNode("a", Subnet, nil)
+	b := g.AddNode("b", Subnet, nil)
+	c := g.AddNode("c", Subnet, nil)
+	d := g.AddNode("d", Subnet, nil)
+	g.AddNeighbour(a, Hosts, b)
+	g.AddNeighbour(b, Hosts, c)
+	g.AddNeighbour(a, Hosts, c)
+	g.AddNeighbour(c, Hosts, d)
+
+	var visited []string
+	g.DFS("a", TraversalOptions{MaxDepth: 2}, func(n NodeRef, depth int, via *Edge) bool {
+		visited = append(visited, n.Id)
+		return true
+	})
+
+	if strings.Join(visited, ",") != "a,b,c,d" {
+		t.Fatalf("visited = %v, want a,b,c,d with d two hops away through the shortcut", visited)
+	}
+}
+
+func Test_Type_should_round_trip_through_json_by_name(t *testing.T) {
+	in := map[Type][]Type{LoadBalancer: {Instance, AvailabilityZone}}
+
+	js, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(js) != `{"elb":["instance","az"]}` {
+		t.Errorf("json = %s", js)
+	}
+
+	var out map[Type][]Type
+	err = json.Unmarshal(js, &out)
+	if err != nil || !reflect.DeepEqual(in, out) {
+		t.Errorf("got %v, %v, want %v", out, err, in)
+	}
+
+	if _, err = ParseType("nope"); err != UnknownType {
+		t.Errorf("err = %v, want UnknownType", err)
+	}
+}
+
+func Test_NodeList_AddNode_should_normalize_properties(t *testing.T) {
+	nodeList := NewNodeList()
+	n := nodeList.AddNode("subnet-1", Subnet, &ec2.Subnet{
+		SubnetID:         aws.String("subnet-1"),
+		VPCID:            aws.String("vpc-1"),
+		AvailabilityZone: aws.String("eu-west-1a"),
+		CIDRBlock:        aws.String("10.0.1.0/24"),
+		Tags:             []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+	})
+
+	expected := Properties{"name": "web", "tag.Name": "web", "vpc": "vpc-1", "az": "eu-west-1a", "cidr": "10.0.1.0/24"}
+	if !reflect.DeepEqual(n.Properties, expected) {
+		t.Errorf("Properties = %v, want %v", n.Properties, expected)
+	}
+
+	n.Properties[PublicProperty] = "true"
+	nodeList.UpdateNodeValue("subnet-1", &ec2.Subnet{SubnetID: aws.String("subnet-1"), State: aws.String("pending")})
+	if !reflect.DeepEqual(n.Properties, Properties{"state": "pending", "public": "true"}) {
+		t.Errorf("Properties = %v after update", n.Properties)
+	}
+}
+
+func Test_BuildGraphReport_should_report_and_optionally_stand_in_for_missing_resources(t *testing.T) {
+	region := syntheticRegion(1, 1, 1)
+	region.Instances[0].SubnetID = aws.String("subnet-deleted")
+	region.LoadBalancers[0].Instances = append(region.LoadBalancers[0].Instances, &elb.Instance{InstanceID: aws.String("i-other-account")})
+
+	graph, report := BuildGraphReport(&Config{Region: "eu-west-1"}, region)
+	if len(report.Unresolved) != 2 || report.Unresolved[0].Placeholder {
+		t.Fatalf("report = %+v, want the subnet and instance unresolved", report)
+	}
+
+	if _, err := graph.GetNode("eu-west-1/subnet/subnet-deleted"); err != NodeNotFound {
+		t.Errorf("err = %v, want no placeholder", err)
+	}
+
+	graph, report = BuildGraphReport(&Config{Region: "eu-west-1", Placeholders: true}, region)
+	expected := UnresolvedReference{"eu-west-1/elb/elb-0-0", Proxies, Instance, "eu-west-1/instance/i-other-account", true}
+	if len(report.Unresolved) != 2 || report.Unresolved[0] != expected {
+		t.Fatalf("report = %+v, want %+v first", report.Unresolved, expected)
+	}
+
+	n, err := graph.GetNode("eu-west-1/subnet/subnet-deleted")
+	if err != nil || !n.Unresolved || n.Type != Subnet {
+		t.Fatalf("placeholder = %+v, %v", n, err)
+	}
+
+	edges := graph.Query().From(n.Id).Rel(IpAllocatedToInstance).Edges()
+	if len(edges) != 1 || edges[0].To.Id != "eu-west-1/instance/i-0-0-0" {
+		t.Errorf("placeholder edges = %v, want the instance", edges)
+	}
+}
+
+func Test_BuildGraphReport_should_report_missing_ids_and_link_security_groups(t *testing.T) {
+	region := syntheticRegion(1, 1, 1)
+	region.Subnets = append(region.Subnets, &ec2.Subnet{SubnetID: aws.String("subnet-bare")})
+	region.Instances = append(region.Instances, &ec2.Instance{InstanceID: aws.String("i-classic"), SecurityGroups: []*ec2.GroupIdentifier{{GroupID: aws.String("sg-web")}}})
+	region.SecurityGroups = []*ec2.SecurityGroup{
+		{GroupID: aws.String("sg-web"), OwnerID: aws.String("111"), IPPermissions: []*ec2.IPPermission{{UserIDGroupPairs: []*ec2.UserIDGroupPair{
+			{GroupID: aws.String("sg-lb"), UserID: aws.String("111")},
+			{GroupID: aws.String("sg-partner"), UserID: aws.String("222")},
+		}}}},
+		{GroupID: aws.String("sg-lb"), OwnerID: aws.String("111")},
+	}
+
+	graph, report := BuildGraphReport(&Config{Region: "eu-west-1"}, region)
+
+	var buf bytes.Buffer
+	report.WriteText(&buf)
+	expected := `eu-west-1/instance/i-classic -[ip_allocated_to_instance]- missing subnet (none)
+eu-west-1/sg/sg-web -[references_group]- missing sg 222/eu-west-1/sg/sg-partner
+eu-west-1/subnet/subnet-bare -[allocates_network]- missing vpc (none)
+eu-west-1/subnet/subnet-bare -[hosts_network]- missing az (none)
+4 unresolved references
+`
+	if buf.String() != expected {
+		t.Fatalf("report =\n%v\nwant\n%v", buf.String(), expected)
+	}
+
+	edges := graph.Query().From("eu-west-1/sg/sg-web").Edges()
+	if len(edges) != 2 || edges[0].To.Id != "eu-west-1/sg/sg-lb" || edges[1].Relationship != Protects {
+		t.Errorf("sg-web edges = %v, want it to reference sg-lb and protect i-classic", edges)
+	}
+}
+
+func Test_Graph_Subgraph_should_select_the_neighbourhood_of_a_seed(t *testing.T) {
+	graph := BuildGraph(&Config{Region: "eu-west-1"}, syntheticRegion(1, 3, 2))
+
+	sub, err := graph.Subgraph(SubgraphOptions{
+		TraversalOptions: TraversalOptions{MaxDepth: 1},
+		Seeds:            []string{"eu-west-1/elb/elb-0-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the elb, its subnet and its two instances, linked both ways to each other
+	if sub.NodeList.Len() != 4 || sub.EdgeList.Len() != 10 {
+		t.Errorf("subgraph has %v nodes and %v edges, want 4 and 10", sub.NodeList.Len(), sub.EdgeList.Len())
+	}
+
+	n, _ := sub.GetNode("eu-west-1/instance/i-0-1-0")
+	n.Properties[StateProperty] = "terminated"
+	n.Properties = nil
+	if original, _ := graph.GetNode("eu-west-1/instance/i-0-1-0"); original.Properties == nil || original.Properties[StateProperty] == "terminated" {
+		t.Errorf("subgraph nodes and their properties should be copies")
+	}
+}
+
+func Test_GraphSelection_should_keep_containers_for_the_dendogram(t *testing.T) {
+	config := &Config{Region: "eu-west-1"}
+	graph := BuildGraph(config, syntheticRegion(2, 3, 2))
+
+	s := &GraphSelection{Types: []string{"instance"}, Props: []string{"az=eu-west-1b", "vpc=vpc-1"}}
+	sub, err := s.Apply(graph, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := GenerateDendogram(config, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(root.Children) != 1 || len(root.Children[0].Children) != 1 || root.Children[0].Children[0].Name != "vpc-1" {
+		t.Fatalf("dendogram = %+v, want only eu-west-1b and vpc-1", root.Children)
+	}
+
+	subnets := root.Children[0].Children[0].Children
+	if len(subnets) != 1 || len(subnets[0].Children) != 2 {
+		t.Errorf("subnets = %+v, want subnet-1-1 and its two instances", subnets)
+	}
+}
+
+// reversedRegion returns region with every resource list reversed, as if collected in another order.
+func reversedRegion(region *AwsRegion) *AwsRegion {
+	r := *region
+	r.Vpcs, r.Subnets, r.Instances, r.LoadBalancers = nil, nil, nil, nil
+	for i := len(region.Vpcs) - 1; i >= 0; i-- {
+		r.Vpcs = append(r.Vpcs, region.Vpcs[i])
+	}
+	for i := len(region.Subnets) - 1; i >= 0; i-- {
+		r.Subnets = append(r.Subnets, region.Subnets[i])
+	}
+	for i := len(region.Instances) - 1; i >= 0; i-- {
+		r.Instances = append(r.Instances, region.Instances[i])
+	}
+	for i := len(region.LoadBalancers) - 1; i >= 0; i-- {
+		r.LoadBalancers = append(r.LoadBalancers, region.LoadBalancers[i])
+	}
+
+	return &r
+}
+
+func Test_NodeList_GetNodes_should_order_by_type_then_id(t *testing.T) {
+	nodeList := NewNodeList()
+	nodeList.AddNode("i-2", Instance, nil)
+	nodeList.AddNode("vpc-2", Vpc, nil)
+	nodeList.AddNode("i-1", Instance, nil)
+	nodeList.AddNode("vpc-1", Vpc, nil)
+
+	var ids []string
+	for _, n := range nodeList.GetNodes() {
+		ids = append(ids, n.Id)
+	}
+
+	if !reflect.DeepEqual(ids, []string{"vpc-1", "vpc-2", "i-1", "i-2"}) {
+		t.Errorf("ids = %v", ids)
+	}
+}
+
+func Test_generateDendogram_should_be_repeatable_whatever_the_collection_order(t *testing.T) {
+	config := &Config{Region: "eu-west-1"}
+	region := syntheticRegion(2, 3, 2)
+
+	var outputs []string
+	for _, r := range []*AwsRegion{region, reversedRegion(region), region} {
+		root, err := GenerateDendogram(config, BuildGraph(config, r))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		js, _ := json.Marshal(root)
+		outputs = append(outputs, string(js))
+	}
+
+	if outputs[0] != outputs[1] || outputs[0] != outputs[2] {
+		t.Fatalf("dendograms differ:\n%v\n%v", outputs[0], outputs[1])
+	}
+
+	var root Dendogram
+	json.Unmarshal([]byte(outputs[0]), &root)
+	if root.Children[0].Name != "eu-west-1a" || root.Children[0].Children[1].Name != "vpc-1" {
+		t.Errorf("children = %v, want azs and vpcs in id order", root.Children)
+	}
+}
+
+func Test_generateDendogram_should_order_by_name_when_configured(t *testing.T) {
+	config := &Config{Region: "eu-west-1", Order: "name"}
+	region := syntheticRegion(1, 1, 3)
+	names := []string{"web", "api", "db"}
+	for i, instance := range region.Instances {
+		instance.Tags[0].Value = aws.String(names[i])
+	}
+	region.LoadBalancers = nil
+
+	root, err := GenerateDendogram(config, BuildGraph(config, region))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, i := range root.Children[0].Children[0].Children[0].Children {
+		got = append(got, i.Name)
+	}
+
+	if !reflect.DeepEqual(got, []string{"api i-0-0-1", "db i-0-0-2", "web i-0-0-0"}) {
+		t.Errorf("instances = %v, want them by name", got)
+	}
+
+	config.Order = "size"
+	if _, err = GenerateDendogram(config, BuildGraph(config, region)); err != UnknownOrder {
+		t.Errorf("err = %v, want UnknownOrder", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// NodeChange is a node added, removed or modified between two graphs. For a
+// modified node Added and Removed list the key=value properties that changed.
+type NodeChange struct {
+	Id      string   `json:"id"`
+	Type    Type     `json:"type"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// EdgeChange is an edge added or removed between two graphs.
+type EdgeChange struct {
+	From         string       `json:"from"`
+	FromType     Type         `json:"from_type"`
+	Relationship Relationship `json:"relationship"`
+	To           string       `json:"to"`
+	ToType       Type         `json:"to_type"`
+}
+
+func (ec *EdgeChange) String() string {
+	return fmt.Sprintf("(%v) -[%v]-> (%v)", ec.From, ec.Relationship, ec.To)
+}
+
+// GraphDiff is the result of comparing two graphs by node identity and edge
+// relationship. Each relationship is reported once, in the direction it was
+// registered in, rather than together with its inverse.
+type GraphDiff struct {
+	AddedNodes    []*NodeChange `json:"added_nodes"`
+	RemovedNodes  []*NodeChange `json:"removed_nodes"`
+	ModifiedNodes []*NodeChange `json:"modified_nodes"`
+	AddedEdges    []*EdgeChange `json:"added_edges"`
+	RemovedEdges  []*EdgeChange `json:"removed_edges"`
+}
+
+// HasChanges
+func (d *GraphDiff) HasChanges() bool {
+	return len(d.AddedNodes)+len(d.RemovedNodes)+len(d.ModifiedNodes)+len(d.AddedEdges)+len(d.RemovedEdges) > 0
+}
+
+func propertyFacts(p Properties) (facts []string) {
+	for k, v := range p {
+		facts = append(facts, k+"="+v)
+	}
+
+	return facts
+}
+
+// reportedEdges keys the edges of g that a diff reports.
+func reportedEdges(g *Graph) map[string]*Edge {
+	edges := make(map[string]*Edge, g.EdgeList.Len())
+	for _, neighbours := range g.Edges {
+		for _, e := range neighbours {
+			if spec, ok := Relationships[e.Relationship]; ok && spec.Inverted {
+				continue
+			}
+			edges[e.From.Id+" "+string(e.Relationship)+" "+e.To.Id] = e
+		}
+	}
+
+	return edges
+}
+
+func edgeChange(e *Edge) *EdgeChange {
+	return &EdgeChange{e.From.Id, e.From.Type, e.Relationship, e.To.Id, e.To.Type}
+}
+
+// DiffGraphs compares two graphs, reporting nodes and edges that were added,
+// removed, or for nodes, whose properties changed.
+func DiffGraphs(old, new *Graph) (d *GraphDiff) {
+	d = &GraphDiff{}
+
+	for _, n := range new.GetNodes() {
+		o, err := old.GetNode(n.Id)
+		if err != nil {
+			d.AddedNodes = append(d.AddedNodes, &NodeChange{Id: n.Id, Type: n.Type})
+			continue
+		}
+
+		added, removed := diffStrings(propertyFacts(o.Properties), propertyFacts(n.Properties))
+		if len(added)+len(removed) > 0 {
+			d.ModifiedNodes = append(d.ModifiedNodes, &NodeChange{n.Id, n.Type, added, removed})
+		}
+	}
+
+	for _, o := range old.GetNodes() {
+		if _, err := new.GetNode(o.Id); err != nil {
+			d.RemovedNodes = append(d.RemovedNodes, &NodeChange{Id: o.Id, Type: o.Type})
+		}
+	}
+
+	oldEdges, newEdges := reportedEdges(old), reportedEdges(new)
+	for key, e := range newEdges {
+		if oldEdges[key] == nil {
+			d.AddedEdges = append(d.AddedEdges, edgeChange(e))
+		}
+	}
+
+	for key, e := range oldEdges {
+		if newEdges[key] == nil {
+			d.RemovedEdges = append(d.RemovedEdges, edgeChange(e))
+		}
+	}
+
+	sortEdgeChanges(d.AddedEdges)
+	sortEdgeChanges(d.RemovedEdges)
+
+	return d
+}
+
+func sortEdgeChanges(changes []*EdgeChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.Relationship != b.Relationship {
+			return a.Relationship < b.Relationship
+		}
+		return a.To < b.To
+	})
+}
+
+// WriteText writes the diff in the style of SnapshotDiff.WriteText, stopping at the first write error.
+func (d *GraphDiff) WriteText(w io.Writer) (err error) {
+	if !d.HasChanges() {
+		_, err = fmt.Fprintln(w, "no changes")
+		return err
+	}
+
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	for _, n := range d.AddedNodes {
+		printf("+ %v\n", n.Id)
+	}
+
+	for _, n := range d.RemovedNodes {
+		printf("- %v\n", n.Id)
+	}
+
+	for _, n := range d.ModifiedNodes {
+		printf("~ %v\n", n.Id)
+		for _, f := range n.Removed {
+			printf("    - %v\n", f)
+		}
+		for _, f := range n.Added {
+			printf("    + %v\n", f)
+		}
+	}
+
+	for _, e := range d.AddedEdges {
+		printf("+ %v\n", e)
+	}
+
+	for _, e := range d.RemovedEdges {
+		printf("- %v\n", e)
+	}
+
+	return err
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
+
+/* graph json.
+
+The canonical form of a built graph, for tools that want the nodes and
+edges without reimplementing buildGraph:
+
+{
+  "format": "awsmap-graph",
+  "version": 1,
+  "metadata": { ...the snapshot metadata... },
+  "report": { "unresolved": [...] },
+  "nodes": [
+    {"id": "123456789012/eu-west-1/vpc/vpc-1", "type": "vpc", "properties": {"cidr": "10.0.0.0/16"}, "value": {...}}
+  ],
+  "edges": [
+    {"from": "123456789012/eu-west-1/region/eu-west-1", "relationship": "hosts", "to": "123456789012/eu-west-1/vpc/vpc-1"}
+  ]
+}
+
+Nodes are sorted by id and edges by from, relationship and to. Every edge
+is listed, so a relationship and its inverse both appear. value is the
+collected SDK resource, left out for the region node which would repeat
+the whole snapshot and for placeholders.
+*/
+
+const GraphFormat = "awsmap-graph"
+const GraphFormatVersion = 1
+
+var UnsupportedGraphFormat = errors.New("Not an awsmap graph or a newer version!")
+
+type graphDocument struct {
+	Format   string            `json:"format"`
+	Version  int               `json:"version"`
+	Metadata *SnapshotMetadata `json:"metadata,omitempty"`
+	Report   *BuildReport      `json:"report,omitempty"`
+	Nodes    []graphNode       `json:"nodes"`
+	Edges    []graphEdge       `json:"edges"`
+}
+
+type graphNode struct {
+	Id         string          `json:"id"`
+	Type       Type            `json:"type"`
+	Properties Properties      `json:"properties,omitempty"`
+	Sources    []string        `json:"sources,omitempty"`
+	Unresolved bool            `json:"unresolved,omitempty"`
+	Value      json.RawMessage `json:"value,omitempty"`
+}
+
+type graphEdge struct {
+	From         string       `json:"from"`
+	Relationship Relationship `json:"relationship"`
+	To           string       `json:"to"`
+	Attributes   Properties   `json:"attributes,omitempty"`
+}
+
+// nodeValues allocate the value decoded for each type of node.
+var nodeValues = map[Type]func() interface{}{
+	Vpc:             func() interface{} { return new(ec2.VPC) },
+	Subnet:          func() interface{} { return new(ec2.Subnet) },
+	RouteTable:      func() interface{} { return new(ec2.RouteTable) },
+	InternetGateway: func() interface{} { return new(ec2.InternetGateway) },
+	Instance:        func() interface{} { return new(ec2.Instance) },
+	LoadBalancer:    func() interface{} { return new(elb.LoadBalancerDescription) },
+	Acl:             func() interface{} { return new(ec2.NetworkACL) },
+	SecurityGroup:   func() interface{} { return new(ec2.SecurityGroup) },
+}
+
+// WriteGraph writes the graph of view in the canonical graph json.
+func WriteGraph(w io.Writer, view *GraphView) (err error) {
+	doc := graphDocument{
+		Format:   GraphFormat,
+		Version:  GraphFormatVersion,
+		Metadata: view.Metadata,
+		Report:   view.Report,
+		Nodes:    make([]graphNode, 0, view.Graph.NodeList.Len()),
+		Edges:    make([]graphEdge, 0, view.Graph.EdgeList.Len()),
+	}
+
+	for _, n := range view.Graph.GetNodes() {
+		gn := graphNode{Id: n.Id, Type: n.Type, Properties: n.Properties, Sources: n.Sources, Unresolved: n.Unresolved}
+		if n.Value != nil && n.Type != Region {
+			gn.Value, err = json.Marshal(n.Value)
+			if err != nil {
+				return err
+			}
+		}
+		doc.Nodes = append(doc.Nodes, gn)
+	}
+	sort.Slice(doc.Nodes, func(a, b int) bool { return doc.Nodes[a].Id < doc.Nodes[b].Id })
+
+	for _, neighbours := range view.Graph.Edges {
+		for _, e := range neighbours {
+			doc.Edges = append(doc.Edges, graphEdge{e.From.Id, e.Relationship, e.To.Id, e.Attributes})
+		}
+	}
+	sort.Slice(doc.Edges, func(a, b int) bool {
+		ea, eb := doc.Edges[a], doc.Edges[b]
+		if ea.From != eb.From {
+			return ea.From < eb.From
+		}
+		if ea.Relationship != eb.Relationship {
+			return ea.Relationship < eb.Relationship
+		}
+		return ea.To < eb.To
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+// ReadGraph loads a graph written by WriteGraph, the view's Config is left for the caller to set.
+func ReadGraph(r io.Reader) (view *GraphView, err error) {
+	var doc graphDocument
+	err = json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Format != GraphFormat || doc.Version > GraphFormatVersion {
+		return nil, UnsupportedGraphFormat
+	}
+
+	graph := NewGraph()
+	for _, gn := range doc.Nodes {
+		var v interface{}
+		if gn.Value != nil {
+			v, err = decodeNodeValue(gn.Type, gn.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		n := graph.AddNode(gn.Id, gn.Type, v)
+		n.Properties = gn.Properties
+		if n.Properties == nil {
+			n.Properties = make(Properties)
+		}
+		n.Sources = gn.Sources
+		n.Unresolved = gn.Unresolved
+	}
+
+	for _, ge := range doc.Edges {
+		from, err := graph.GetNode(ge.From)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := graph.GetNode(ge.To)
+		if err != nil {
+			return nil, err
+		}
+
+		graph.AddNeighbour(from, ge.Relationship, to).Attributes = ge.Attributes
+	}
+
+	if doc.Metadata == nil {
+		doc.Metadata = &SnapshotMetadata{}
+	}
+
+	if doc.Report == nil {
+		doc.Report = &BuildReport{}
+	}
+
+	return &GraphView{Graph: graph, Metadata: doc.Metadata, Report: doc.Report}, nil
+}
+
+func decodeNodeValue(t Type, raw json.RawMessage) (v interface{}, err error) {
+	if t == AvailabilityZone {
+		var az string
+		err = json.Unmarshal(raw, &az)
+		return az, err
+	}
+
+	alloc, ok := nodeValues[t]
+	if !ok {
+		return nil, nil
+	}
+
+	v = alloc()
+	err = json.Unmarshal(raw, v)
+
+	return v, err
+}
+
+func loadGraph(filename string) (view *GraphView, err error) {
+	r, err := openSnapshot(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ReadGraph(r)
+}
+
+func saveGraph(filename string, view *GraphView) (err error) {
+	w, err := createSnapshot(filename, os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	err = WriteGraph(w, view)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,65 @@
+package main_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+)
+import . "."
+
+func Test_ReadGraph_should_round_trip_a_built_graph(t *testing.T) {
+	region := syntheticRegion(1, 2, 2)
+	region.Instances[0].SubnetID = aws.String("subnet-deleted")
+	config := &Config{Account: "123456789012", Region: "eu-west-1", Placeholders: true}
+	graph, report := BuildGraphReport(config, region)
+	view := &GraphView{Graph: graph, Config: config, Metadata: &SnapshotMetadata{Account: "123456789012", Region: "eu-west-1"}, Report: report}
+
+	var first, second bytes.Buffer
+	err := WriteGraph(&first, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ReadGraph(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Config = config
+
+	err = WriteGraph(&second, loaded)
+	if err != nil || first.String() != second.String() {
+		t.Fatalf("err = %v, rewritten graph differs:\n%v\n%v", err, first.String(), second.String())
+	}
+
+	n, err := loaded.Graph.GetNode("123456789012/eu-west-1/instance/i-0-1-0")
+	if err != nil || *n.Value.(*ec2.Instance).PrivateIPAddress != "10.0.1.0" || n.Properties["az"] != "eu-west-1b" {
+		t.Errorf("node = %+v, %v, want the instance value and properties", n, err)
+	}
+
+	placeholder, err := loaded.Graph.GetNode("123456789012/eu-west-1/subnet/subnet-deleted")
+	if err != nil || !placeholder.Unresolved || len(loaded.Report.Unresolved) != 1 {
+		t.Errorf("placeholder = %+v, report = %+v", placeholder, loaded.Report)
+	}
+
+	want, _ := GenerateDendogram(config, graph)
+	got, err := GenerateDendogram(config, loaded.Graph)
+	if err != nil || !reflect.DeepEqual(want, got) {
+		t.Errorf("dendogram of the loaded graph differs, err = %v", err)
+	}
+}
+
+func Test_WriteGraph_should_be_repeatable_whatever_the_collection_order(t *testing.T) {
+	config := &Config{Region: "eu-west-1"}
+	region := syntheticRegion(2, 2, 2)
+
+	var a, b bytes.Buffer
+	WriteGraph(&a, &GraphView{Graph: BuildGraph(config, region), Config: config})
+	WriteGraph(&b, &GraphView{Graph: BuildGraph(config, reversedRegion(region)), Config: config})
+
+	if a.String() != b.String() {
+		t.Errorf("graph json differs:\n%v\n%v", a.String(), b.String())
+	}
+}
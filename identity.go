@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var AmbiguousId = errors.New("Id matches more than one node!")
+
+// Identity qualifies a resource id by account, region and type, so an ELB
+// named eu-west-1 doesn't collide with the region and merged accounts don't
+// collide with each other, e.g. 123456789012/eu-west-1/elb/api. An unknown
+// account or region is left out, eu-west-1/elb/api or elb/api.
+// AWS ids and names never contain a /, so identities are parsed from the right.
+type Identity string
+
+// NewIdentity
+func NewIdentity(account, region string, t Type, id string) Identity {
+	parts := make([]string, 0, 4)
+	if account != "" {
+		parts = append(parts, account)
+	}
+	if region != "" || account != "" {
+		parts = append(parts, region)
+	}
+
+	return Identity(strings.Join(append(parts, t.String(), id), "/"))
+}
+
+func (i Identity) part(fromRight int) string {
+	parts := strings.Split(string(i), "/")
+	if fromRight >= len(parts) {
+		return ""
+	}
+
+	return parts[len(parts)-1-fromRight]
+}
+
+// Id returns the short id, the AWS id or name the identity qualifies.
+func (i Identity) Id() string {
+	return i.part(0)
+}
+
+// Type
+func (i Identity) Type() (Type, error) {
+	return ParseType(i.part(1))
+}
+
+// Region
+func (i Identity) Region() string {
+	return i.part(2)
+}
+
+// Account
+func (i Identity) Account() string {
+	return i.part(3)
+}
+
+// Matches reports whether id is the identity or a suffix of it, e.g. api, elb/api or eu-west-1/elb/api.
+func (i Identity) Matches(id string) bool {
+	return string(i) == id || strings.HasSuffix(string(i), "/"+id)
+}
+
+// LookupAll returns the nodes matching id, a full identity or a suffix of one, sorted by identity.
+func (nl NodeList) LookupAll(id string) (nodes []NodeRef) {
+	if n, ok := nl[id]; ok {
+		return []NodeRef{n}
+	}
+
+	for key, n := range nl {
+		if Identity(key).Matches(id) {
+			nodes = append(nodes, n)
+		}
+	}
+
+	sort.Slice(nodes, func(a, b int) bool { return nodes[a].Id < nodes[b].Id })
+
+	return nodes
+}
+
+// Lookup returns the node matching id, which may be a short AWS id as long as only one node has it.
+func (nl NodeList) Lookup(id string) (n NodeRef, err error) {
+	nodes := nl.LookupAll(id)
+	switch len(nodes) {
+	case 0:
+		return nil, NodeNotFound
+	case 1:
+		return nodes[0], nil
+	}
+
+	return nil, AmbiguousId
+}
+
+// ShortIds indexes the nodes by short id, for resolving many short ids at once.
+func (nl NodeList) ShortIds() (index map[string][]NodeRef) {
+	index = make(map[string][]NodeRef, len(nl))
+	for key, n := range nl {
+		short := Identity(key).Id()
+		index[short] = append(index[short], n)
+	}
+
+	return index
+}
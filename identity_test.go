@@ -0,0 +1,49 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
+import . "."
+
+func Test_Identity_should_qualify_ids_by_account_region_and_type(t *testing.T) {
+	id := NewIdentity("123456789012", "eu-west-1", LoadBalancer, "api")
+	if id != "123456789012/eu-west-1/elb/api" {
+		t.Fatalf("id = %v", id)
+	}
+
+	typ, err := id.Type()
+	if id.Account() != "123456789012" || id.Region() != "eu-west-1" || typ != LoadBalancer || err != nil || id.Id() != "api" {
+		t.Errorf("parts = %v %v %v %v", id.Account(), id.Region(), typ, id.Id())
+	}
+
+	if short := NewIdentity("", "eu-west-1", Vpc, "vpc-1"); short != "eu-west-1/vpc/vpc-1" || short.Account() != "" {
+		t.Errorf("id = %v, want the account left out", short)
+	}
+}
+
+func Test_NodeList_Lookup_should_accept_short_ids_unless_ambiguous(t *testing.T) {
+	region := syntheticRegion(1, 1, 1)
+	region.LoadBalancers = append(region.LoadBalancers, &elb.LoadBalancerDescription{LoadBalancerName: aws.String("eu-west-1")})
+	graph := BuildGraph(&Config{Account: "123456789012", Region: "eu-west-1"}, region)
+
+	n, err := graph.Lookup("i-0-0-0")
+	if err != nil || n.Id != "123456789012/eu-west-1/instance/i-0-0-0" {
+		t.Errorf("Lookup = %v, %v", n, err)
+	}
+
+	if _, err = graph.Lookup("eu-west-1"); err != AmbiguousId {
+		t.Errorf("err = %v, want AmbiguousId for the region and the elb named after it", err)
+	}
+
+	n, err = graph.Lookup("elb/eu-west-1")
+	if err != nil || n.Type != LoadBalancer {
+		t.Errorf("Lookup = %v, %v, want the elb", n, err)
+	}
+
+	if _, err = graph.Lookup("i-nope"); err != NodeNotFound {
+		t.Errorf("err = %v, want NodeNotFound", err)
+	}
+}
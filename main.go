@@ -1,23 +1,44 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/awslabs/aws-sdk-go/service/ec2"
 	"github.com/awslabs/aws-sdk-go/service/elb"
 )
 
+// Version is the awsmap release, set at build time with -ldflags "-X main.Version=...".
+var Version = "dev"
+
 type Config struct {
+	Account       string
 	Region        string
 	InstanceCount int64
 	IsDownload    bool
 	IsServe       bool
 	Filename      string
+	TfState       string
+	StoreDir      string
+	Compression   string
+	Retention     RetentionPolicy
+	Snapshot      string
+	At            string
+	Refresh       time.Duration
+	Placeholders  bool
+	Graph         string
+	Selection     GraphSelection
+	Order         string
+	Rules         string
+
+	// owners are the accounts of the resources of a merge of several accounts, see Snapshot.Accounts.
+	owners map[interface{}]string
 }
 
 func main() {
@@ -27,47 +48,70 @@ func main() {
 	flag.BoolVar(&config.IsDownload, "download", false, "Retrieve latest data.")
 	flag.Int64Var(&config.InstanceCount, "instances", 100, "Number of running instances.")
 	flag.StringVar(&config.Region, "region", "eu-west-1", "AWS region to map.")
-	flag.StringVar(&config.Filename, "filename", "region.json", "Storage location of JSON files.")
+	flag.StringVar(&config.Filename, "filename", "region.json", "Storage location of JSON files, compressed when ending in .gz or .zst. -serve merges a comma separated list.")
+	flag.StringVar(&config.TfState, "tfstate", "", "Import a Terraform state file instead of downloading.")
+	flag.StringVar(&config.StoreDir, "store", "", "Directory of timestamped snapshots, used instead of -filename.")
+	flag.StringVar(&config.Compression, "compress", "", "Compress -store snapshots with gz or zst.")
+	flag.IntVar(&config.Retention.MaxCount, "keep", 0, "Number of snapshots to keep in -store, 0 keeps all.")
+	flag.DurationVar(&config.Retention.MaxAge, "max-age", 0, "Age after which snapshots are removed from -store, 0 keeps all.")
+	flag.StringVar(&config.Snapshot, "snapshot", "", "Name of the -store snapshot to serve, defaults to the latest.")
+	flag.StringVar(&config.At, "at", "", "Serve the -store snapshot current at this RFC3339 time.")
+	flag.DurationVar(&config.Refresh, "refresh", 0, "Interval at which -serve reloads, or with -download recollects, its snapshot. 0 disables.")
+	flag.BoolVar(&config.Placeholders, "placeholders", false, "Add placeholder nodes for resources referenced but missing from the snapshot.")
+	flag.StringVar(&config.Graph, "graph", "", "Load a graph written by awsmap graph instead of building one from a snapshot.")
+	flag.Var(&config.Selection.Seeds, "seed", "Comma separated ids to select the subgraph around, see -hops.")
+	flag.IntVar(&config.Selection.Hops, "hops", 0, "Hops from the -seed nodes to select, 0 is unlimited.")
+	flag.Var(&config.Selection.Types, "select-type", "Comma separated node types to select, e.g. elb,instance.")
+	flag.Var(&config.Selection.Rels, "select-rel", "Comma separated relationships the selection may follow.")
+	flag.Var(&config.Selection.Props, "select-prop", "Comma separated key=value node properties to select, e.g. vpc=vpc-1.")
+	flag.BoolVar(&config.Selection.Containers, "containers", false, "Keep the region, AZs, VPCs and subnets enclosing selected nodes.")
+	flag.StringVar(&config.Order, "order", "id", "Order the nodes of each type in the tree by id or name.")
+	flag.StringVar(&config.Rules, "rules", "", "Rules file checked by awsmap rules and served at /rules.json.")
 
 	flag.Parse()
 
-	var region *AwsRegion
-	var err error
-
-	if config.IsDownload {
-		region, err = fetchRegion(config)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		os.Exit(cmd(config, flag.Args()[1:]))
+	}
 
-		f, err := os.OpenFile(config.Filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatal(err)
-		}
+	store, err := openStore(config)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		enc := json.NewEncoder(f)
-		err = enc.Encode(region)
+	if config.IsDownload || config.TfState != "" {
+		err = collectSnapshot(config, store)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
 	if config.IsServe {
-		f, err := os.Open(config.Filename)
+		view, err := loadView(config, store)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		dec := json.NewDecoder(f)
-		err = dec.Decode(&region)
-		if err != nil {
-			log.Fatal(err)
+		handler := NewGraphHandler(view, store)
+		if config.Rules != "" {
+			handler.Rules, err = LoadRules(config.Rules)
+			if err != nil {
+				log.Fatal(err)
+			}
+			handler.Rules.MaxSteps = DefaultMaxSteps
 		}
 
-		graph := buildGraph(config, region)
-		handler := &GraphHandler{
-			graph,
-			config,
+		if config.Refresh > 0 {
+			go handler.Refresh(config.Refresh, func() (*GraphView, error) {
+				if config.IsDownload || config.TfState != "" {
+					err := collectSnapshot(config, store)
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				return loadView(config, store)
+			})
 		}
 
 		server := &http.Server{
@@ -79,46 +123,175 @@ func main() {
 	}
 }
 
-type GraphHandler struct {
-	*Graph
-	*Config
-}
+// openStore opens the -store snapshot directory, returning nil when -filename is used instead.
+func openStore(config *Config) (store *SnapshotStore, err error) {
+	if config.StoreDir == "" {
+		return nil, nil
+	}
 
-type Dendogram struct {
-	Name     string       `json:"name"`
-	Children []*Dendogram `json:"children,omitempty"`
+	store, err = NewSnapshotStore(config.StoreDir, config.Retention)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Compression != "" {
+		store.Compression = "." + strings.TrimPrefix(config.Compression, ".")
+		if store.Compression != GzipExt && store.Compression != ZstdExt {
+			return nil, UnknownCompression
+		}
+	}
+
+	return store, nil
 }
 
-func (gs *GraphHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if req.URL.Path == "/" {
-		fmt.Fprintf(w, IndexPage)
-		return
+// collectSnapshot downloads the region, or imports it from Terraform, and saves it to the store or -filename.
+func collectSnapshot(config *Config, store *SnapshotStore) (err error) {
+	var snapshot *Snapshot
+
+	if config.TfState != "" {
+		region, err := importRegion(config)
+		if err != nil {
+			return err
+		}
+		snapshot = NewSnapshot(config, "terraform:"+config.TfState, []string{"terraform"}, region)
+	} else {
+		region, err := fetchRegion(config)
+		if err != nil {
+			return err
+		}
+		snapshot = NewSnapshot(config, "aws", CollectorNames(), region)
+	}
+
+	if store != nil {
+		_, err = store.Save(snapshot)
+		return err
 	}
 
-	if req.URL.Path == "/region.json" {
-		root, err := generateDendogram(gs.Config, gs.Graph)
+	return saveSnapshot(config.Filename, snapshot)
+}
+
+// loadView loads the snapshot selected by the flags and builds its graph.
+func loadView(config *Config, store *SnapshotStore) (view *GraphView, err error) {
+	var snapshot *Snapshot
+
+	if config.Graph != "" {
+		view, err = loadGraph(config.Graph)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return nil, err
 		}
 
-		enc := json.NewEncoder(w)
+		view.Config = snapshotConfig(config, view.Metadata)
+		return view, nil
+	}
+
+	if store != nil {
+		snapshot, err = selectSnapshot(store, config.Snapshot, config.At)
+	} else {
+		snapshot, err = loadMerged(strings.Split(config.Filename, ","))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotView(config, snapshot), nil
+}
+
+func importRegion(config *Config) (region *AwsRegion, err error) {
+	f, err := os.Open(config.TfState)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	region, unmapped, err := ImportTerraformState(f)
+	if err != nil {
+		return nil, err
+	}
 
-		err = enc.Encode(root)
+	for _, addr := range unmapped {
+		log.Println("unmapped terraform resource: " + addr)
+	}
+
+	return region, nil
+}
+
+// selectSnapshot loads the named snapshot, the one current at the RFC3339 time at, or the latest.
+func selectSnapshot(store *SnapshotStore, name, at string) (snapshot *Snapshot, err error) {
+	var stored *StoredSnapshot
+	var t time.Time
+
+	switch {
+	case name != "":
+		return store.Load(name)
+	case at != "":
+		t, err = time.Parse(time.RFC3339, at)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return nil, err
 		}
+		stored, err = store.Closest(t)
+	default:
+		stored, err = store.Latest()
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
-		return
+	return store.Load(stored.Name)
+}
+
+// snapshotConfig returns a copy of config for rendering a snapshot, using the account and region it was collected in.
+func snapshotConfig(config *Config, metadata *SnapshotMetadata) *Config {
+	c := *config
+	c.Account = metadata.Account
+	if metadata.Region != "" {
+		c.Region = metadata.Region
 	}
 
-	http.NotFound(w, req)
+	return &c
+}
+
+// snapshotView builds the graph of snapshot, recording the sources of merged snapshots on each node.
+func snapshotView(config *Config, snapshot *Snapshot) *GraphView {
+	config = snapshotConfig(config, &snapshot.Metadata)
+	config.owners = snapshot.owners()
+	graph, report := buildGraphReport(config, snapshot.Region)
+	applyProvenance(graph, snapshot.Provenance)
+
+	if report.HasProblems() {
+		log.Printf("%d unresolved references, see awsmap report.", len(report.Unresolved))
+	}
+
+	return &GraphView{graph, config, &snapshot.Metadata, report, snapshot.Region}
+}
+
+type Dendogram struct {
+	Name       string       `json:"name"`
+	Type       Type         `json:"type"`
+	Sources    []string     `json:"sources,omitempty"`
+	Properties Properties   `json:"properties,omitempty"`
+	Children   []*Dendogram `json:"children,omitempty"`
+
+	// Id is the node the entry is drawn for.
+	Id string `json:"id"`
+}
+
+// sortDendogram orders the children of every entry of d.
+func sortDendogram(d *Dendogram, order NodeOrder) {
+	nodes := make(map[*Dendogram]NodeRef, len(d.Children))
+	for _, c := range d.Children {
+		nodes[c] = &Node{Id: c.Id, Type: c.Type, Properties: c.Properties}
+		sortDendogram(c, order)
+	}
+
+	sort.SliceStable(d.Children, func(i, j int) bool {
+		return order(nodes[d.Children[i]], nodes[d.Children[j]])
+	})
 }
 
 func IsFromAz(az string) RelationshipFilterFunc {
 	return func(e *Edge) bool {
-		return az == e.From.Id
+		return az == Identity(e.From.Id).Id()
 	}
 }
 
@@ -146,16 +319,25 @@ func IsToSubnetInVpc(vpc string) RelationshipFilterFunc {
 			return false
 		}
 
-		return vpc == *sn.VPCID
+		return vpc == stringValue(sn.VPCID)
 	}
 }
 
+// generateDendogram draws the graph as a tree of AZs, VPCs, subnets, ELBs and
+// instances, with the children of each entry sorted by config.Order.
 func generateDendogram(config *Config, graph *Graph) (root *Dendogram, err error) {
+	order, err := ParseNodeOrder(config.Order)
+	if err != nil {
+		return nil, err
+	}
+
 	root = &Dendogram{
 		Name: config.Region,
+		Type: Region,
+		Id:   nodeId(config, Region, config.Region),
 	}
 
-	azs, err := graph.GetNeighbours(config.Region)
+	azs, err := graph.GetNeighbours(root.Id)
 	if err != nil {
 		return nil, err
 	}
@@ -163,29 +345,29 @@ func generateDendogram(config *Config, graph *Graph) (root *Dendogram, err error
 	instanceSeen := make(map[string]bool)
 
 	for _, relationship := range azs {
-		if relationship.Relationship == "houses" {
-			az := &Dendogram{Name: relationship.To.Id}
+		if relationship.Relationship == Houses {
+			azId := relationship.To.Id
+			az := &Dendogram{Name: Identity(azId).Id(), Type: AvailabilityZone, Properties: relationship.To.Properties, Id: azId}
 			root.Children = append(root.Children, az)
 
+			// group the az's subnets by vpc once rather than querying per vpc.
+			subnetsByVpc := make(map[string]Neighbours)
+			for _, n := range graph.Query().From(azId).Rel(HostsNetwork).Edges() {
+				vpc := n.To.Properties[VpcProperty]
+				subnetsByVpc[vpc] = append(subnetsByVpc[vpc], n)
+			}
+
 			for _, vpc := range azs {
-				if vpc.Relationship == "hosts" {
-					vpcNode := &Dendogram{Name: vpc.To.Id}
+				if vpc.Relationship == Hosts {
+					vpcNode := &Dendogram{Name: Identity(vpc.To.Id).Id(), Type: Vpc, Properties: vpc.To.Properties, Id: vpc.To.Id}
 					az.Children = append(az.Children, vpcNode)
-					for _, n := range graph.GetNeighboursBy(IsToSubnetInVpc(vpcNode.Name), IsFromAz(az.Name)) {
-						subnet := &Dendogram{Name: n.To.Id}
-						name := ""
-						sn := n.To.Value.(*ec2.Subnet)
-						for _, tag := range sn.Tags {
-							if *tag.Key == "Name" {
-								name = *tag.Value
-							}
-						}
-
-						subnet.Name = subnet.Name + " " + name
+					for _, n := range subnetsByVpc[vpcNode.Name] {
+						subnet := &Dendogram{Name: Identity(n.To.Id).Id(), Type: Subnet, Sources: n.To.Sources, Properties: n.To.Properties, Id: n.To.Id}
+						subnet.Name = subnet.Name + " " + n.To.Properties[TagPrefix+"Name"]
 
 						vpcNode.Children = append(vpcNode.Children, subnet)
-						for _, elbs := range graph.GetNeighboursBy(IsFromSubnet(n.To.Id), IsToA(LoadBalancer)) {
-							elbDendogram := &Dendogram{Name: elbs.To.Id}
+						for _, elbs := range graph.Query().From(n.To.Id).ToType(LoadBalancer).Edges() {
+							elbDendogram := &Dendogram{Name: Identity(elbs.To.Id).Id(), Type: LoadBalancer, Sources: elbs.To.Sources, Properties: elbs.To.Properties, Id: elbs.To.Id}
 							subnet.Children = append(subnet.Children, elbDendogram)
 
 							elbDesc, ok := elbs.To.Value.(*elb.LoadBalancerDescription)
@@ -194,25 +376,32 @@ func generateDendogram(config *Config, graph *Graph) (root *Dendogram, err error
 								break
 							}
 
+							// the instances as buildGraph resolved them, which may be of another account than the ELB.
+							proxied := make(map[string]string)
+							for _, p := range graph.Query().From(elbs.To.Id).Rel(Proxies).Edges() {
+								proxied[Identity(p.To.Id).Id()] = p.To.Id
+							}
+
 							for _, elbInstance := range elbDesc.Instances {
-								instanceId := *elbInstance.InstanceID
-								i := &Dendogram{Name: instanceId}
+								instanceId := stringValue(elbInstance.InstanceID)
+								if instanceId == "" {
+									continue
+								}
+								id, ok := proxied[instanceId]
+								if !ok {
+									id = string(NewIdentity(Identity(elbs.To.Id).Account(), config.Region, Instance, instanceId))
+								}
+								i := &Dendogram{Name: instanceId, Type: Instance, Id: id}
 								elbDendogram.Children = append(elbDendogram.Children, i)
 								instanceSeen[instanceId] = true
 							}
 						}
 
-						for _, instanceRel := range graph.GetNeighboursBy(IsFromSubnet(n.To.Id), IsToA(Instance)) {
-							i := &Dendogram{Name: instanceRel.To.Id}
-							inst := instanceRel.To.Value.(*ec2.Instance)
-							name := ""
-							for _, tag := range inst.Tags {
-								if *tag.Key == "Name" {
-									name = *tag.Value
-								}
-							}
+						for _, instanceRel := range graph.Query().From(n.To.Id).ToType(Instance).Edges() {
+							i := &Dendogram{Name: Identity(instanceRel.To.Id).Id(), Type: Instance, Sources: instanceRel.To.Sources, Properties: instanceRel.To.Properties, Id: instanceRel.To.Id}
+							name := instanceRel.To.Properties[TagPrefix+"Name"]
 
-							if instanceSeen[instanceRel.To.Id] {
+							if instanceSeen[i.Name] {
 								i.Name = "<<" + i.Name + ">>"
 							}
 
@@ -226,93 +415,239 @@ func generateDendogram(config *Config, graph *Graph) (root *Dendogram, err error
 		}
 	}
 
+	sortDendogram(root, order)
+
 	return root, nil
 }
 
-const (
-	Region Type = iota
-	Vpc
-	Subnet
-	AvailabilityZone
-	RouteTable
-	InternetGateway
-	Instance
-	LoadBalancer
-	Acl
-)
+// nodeId qualifies a resource id by the account and region being rendered.
+func nodeId(config *Config, t Type, id string) string {
+	return string(NewIdentity(config.Account, config.Region, t, id))
+}
+
+// resourceNodeId returns the identity of resource v, in the account it was
+// collected in when snapshots of several accounts are merged.
+func resourceNodeId(config *Config, t Type, v interface{}) string {
+	account, ok := config.owners[v]
+	if !ok {
+		account = config.Account
+	}
+
+	return string(NewIdentity(account, config.Region, t, resourceId(v)))
+}
 
 func buildGraph(config *Config, region *AwsRegion) (graph *Graph) {
+	graph, _ = buildGraphReport(config, region)
+	return graph
+}
+
+// buildGraphReport builds the graph of region, reporting references to
+// resources missing from it. With config.Placeholders each missing resource
+// is added as an unresolved node so the reference is kept as an edge.
+// Resources without an id of their own are left out, references are to
+// resources in the same account unless they name another.
+func buildGraphReport(config *Config, region *AwsRegion) (graph *Graph, report *BuildReport) {
 	graph = NewGraph()
+	report = &BuildReport{}
+	routes := newReachability(config, region)
+
+	// add adds the node of a resource, indexed by type and short id to resolve references across the accounts of a merge.
+	byShortId := make(map[string]NodeRef)
+	add := func(v interface{}, t Type) NodeRef {
+		n := graph.AddNode(resourceNodeId(config, t, v), t, v)
+		byShortId[t.String()+"/"+resourceId(v)] = n
+		return n
+	}
+
+	// resolveId finds the node of the identity referenced by referrer, an empty
+	// id is a reference the resource doesn't have, such as an EC2-Classic
+	// instance's subnet, and never gets a placeholder.
+	resolveId := func(referrer NodeRef, rel Relationship, t Type, id string) (n NodeRef, ok bool) {
+		if Identity(id).Id() == "" {
+			report.Unresolved = append(report.Unresolved, UnresolvedReference{referrer.Id, rel, t, "", false})
+			return nil, false
+		}
+
+		n, err := graph.GetNode(id)
+		shared, ok := byShortId[t.String()+"/"+Identity(id).Id()]
+		if err == NodeNotFound && ok && config.owners != nil && t != LoadBalancer {
+			// AWS ids are unique across accounts, a shared subnet or peered group may be in another one.
+			n, err = shared, nil
+		}
+		if err == nil && !n.Unresolved {
+			return n, true
+		}
+
+		report.Unresolved = append(report.Unresolved, UnresolvedReference{referrer.Id, rel, t, id, config.Placeholders})
+		if !config.Placeholders {
+			return nil, false
+		}
+
+		if n == nil {
+			n = graph.AddNode(id, t, nil)
+			n.Unresolved = true
+		}
+
+		return n, true
+	}
+
+	resolve := func(referrer NodeRef, rel Relationship, t Type, id string) (n NodeRef, ok bool) {
+		return resolveId(referrer, rel, t, string(NewIdentity(Identity(referrer.Id).Account(), config.Region, t, id)))
+	}
+
 	// add region as root
-	regionNode := graph.AddNode(config.Region, Region, region)
+	regionNode := graph.AddNode(nodeId(config, Region, config.Region), Region, region)
 
 	// add VPCs
 	for _, vpc := range region.Vpcs {
-		vpcNode := graph.AddNode(*vpc.VPCID, Vpc, vpc)
-		graph.AddNeighbour(regionNode, "hosts", vpcNode)
-		graph.AddNeighbour(vpcNode, "hosted_by", regionNode)
+		if vpc.VPCID == nil {
+			continue
+		}
+
+		vpcNode := add(vpc, Vpc)
+		addEdge(graph, regionNode, Hosts, vpcNode)
 	}
 
 	// add subnets and AZs
 	for _, net := range region.Subnets {
-		subnetNode := graph.AddNode(*net.SubnetID, Subnet, net)
+		if net.SubnetID == nil {
+			continue
+		}
 
-		azNode, err := graph.GetNode(*net.AvailabilityZone)
-		if err == NodeNotFound {
-			azNode = graph.AddNode(*net.AvailabilityZone, AvailabilityZone, *net.AvailabilityZone)
-			graph.AddNeighbour(regionNode, "houses", azNode)
-			graph.AddNeighbour(azNode, "housed_by", regionNode)
+		subnetNode := add(net, Subnet)
+		subnetNode.Properties[PublicProperty] = strconv.FormatBool(routes.isPublic(*net.SubnetID, stringValue(net.VPCID)))
+
+		if net.AvailabilityZone == nil {
+			report.Unresolved = append(report.Unresolved, UnresolvedReference{subnetNode.Id, HostsNetwork, AvailabilityZone, "", false})
+		} else {
+			azId := nodeId(config, AvailabilityZone, *net.AvailabilityZone)
+			azNode, err := graph.GetNode(azId)
+			if err == NodeNotFound {
+				azNode = graph.AddNode(azId, AvailabilityZone, *net.AvailabilityZone)
+				addEdge(graph, regionNode, Houses, azNode)
+			}
+			addEdge(graph, azNode, HostsNetwork, subnetNode)
 		}
 
-		vpcNode, err := graph.GetNode(*net.VPCID)
-		if err != nil {
-			log.Printf("subnet[%v] not associated with a known vpc[%v].\n", *net.SubnetID, *net.VPCID)
+		vpcNode, ok := resolve(subnetNode, AllocatesNetwork, Vpc, stringValue(net.VPCID))
+		if !ok {
 			continue
 		}
-		graph.AddNeighbour(vpcNode, "allocates_network", subnetNode)
-		graph.AddNeighbour(subnetNode, "network_allocated_by", vpcNode)
-		graph.AddNeighbour(azNode, "hosts_network", subnetNode)
-		graph.AddNeighbour(subnetNode, "network_hosted_by", azNode)
+		addEdge(graph, vpcNode, AllocatesNetwork, subnetNode)
+	}
+
+	// add SGs, then the groups their rules reference, which may be in another account
+	for _, sg := range region.SecurityGroups {
+		if sg.GroupID == nil {
+			continue
+		}
+
+		add(sg, SecurityGroup)
+	}
+
+	for _, sg := range region.SecurityGroups {
+		if sg.GroupID == nil {
+			continue
+		}
+
+		sgNode, _ := graph.GetNode(resourceNodeId(config, SecurityGroup, sg))
+		referenced := make(map[string]bool)
+		for _, perm := range append(sg.IPPermissions, sg.IPPermissionsEgress...) {
+			for _, pair := range perm.UserIDGroupPairs {
+				if pair.GroupID == nil {
+					continue
+				}
+
+				account := Identity(sgNode.Id).Account()
+				if user := stringValue(pair.UserID); user != "" && user != stringValue(sg.OwnerID) {
+					account = user
+				}
+				id := string(NewIdentity(account, config.Region, SecurityGroup, *pair.GroupID))
+				if referenced[id] {
+					continue
+				}
+				referenced[id] = true
+
+				groupNode, ok := resolveId(sgNode, ReferencesGroup, SecurityGroup, id)
+				if !ok {
+					continue
+				}
+				addEdge(graph, sgNode, ReferencesGroup, groupNode)
+			}
+		}
 	}
 
 	// add instances
 	for _, i := range region.Instances {
-		instanceNode := graph.AddNode(*i.InstanceID, Instance, i)
-		subnetNode, err := graph.GetNode(*i.SubnetID)
-		if err != nil {
-			log.Printf("instance[%v] not associated with a known subnet[%v].", *i.InstanceID, *i.SubnetID)
+		if i.InstanceID == nil {
+			continue
+		}
+
+		instanceNode := add(i, Instance)
+		for _, g := range i.SecurityGroups {
+			if g.GroupID == nil {
+				// EC2-Classic groups may be referenced by name alone.
+				continue
+			}
+
+			sgNode, ok := resolve(instanceNode, Protects, SecurityGroup, *g.GroupID)
+			if ok {
+				addEdge(graph, sgNode, Protects, instanceNode)
+			}
 		}
 
-		graph.AddNeighbour(subnetNode, "allocates_ip", instanceNode)
-		graph.AddNeighbour(instanceNode, "ip_allocated_from", subnetNode)
+		subnetNode, ok := resolve(instanceNode, IpAllocatedToInstance, Subnet, stringValue(i.SubnetID))
+		if !ok {
+			continue
+		}
 
+		addEdge(graph, subnetNode, IpAllocatedToInstance, instanceNode)
 	}
 
 	// add elbs
 	for _, elb := range region.LoadBalancers {
-		elbNode := graph.AddNode(*elb.LoadBalancerName, LoadBalancer, elb)
+		if elb.LoadBalancerName == nil {
+			continue
+		}
+
+		elbNode := add(elb, LoadBalancer)
+		for _, sgId := range elb.SecurityGroups {
+			sgNode, ok := resolve(elbNode, Protects, SecurityGroup, stringValue(sgId))
+			if ok {
+				addEdge(graph, sgNode, Protects, elbNode)
+			}
+		}
+
 		for _, subnetId := range elb.Subnets {
-			subnetNode, err := graph.GetNode(*subnetId)
-			if err != nil {
+			subnetNode, ok := resolve(elbNode, Homes, Subnet, stringValue(subnetId))
+			if !ok {
 				continue
 			}
-			graph.AddNeighbour(subnetNode, "homes", elbNode)
-			graph.AddNeighbour(elbNode, "homed_in", subnetNode)
+			addEdge(graph, subnetNode, Homes, elbNode)
 		}
 
 		for _, instance := range elb.Instances {
-			instanceNode, err := graph.GetNode(*instance.InstanceID)
-			if err != nil {
+			instanceNode, ok := resolve(elbNode, Proxies, Instance, stringValue(instance.InstanceID))
+			if !ok {
 				continue
 			}
-			graph.AddNeighbour(elbNode, "proxies", instanceNode)
-			graph.AddNeighbour(instanceNode, "proxied_by", elbNode)
+			addEdge(graph, elbNode, Proxies, instanceNode)
 		}
 	}
 
-	// add SGs, IGW, ACLS, Routes
+	// add IGW, ACLS, Routes
 
-	return graph
+	report.sort()
+
+	return graph, report
+}
+
+// addEdge adds rel and its inverse, logging edges the registry rejects.
+func addEdge(graph *Graph, from NodeRef, rel Relationship, to NodeRef) {
+	err := graph.AddEdge(from, rel, to)
+	if err != nil {
+		log.Printf("%v[%v] -[%v]-> %v[%v]: %v", from.Type, from.Id, rel, to.Type, to.Id, err)
+	}
 }
 
 const IndexPage = `<!DOCTYPE html>
@@ -329,6 +664,53 @@ const IndexPage = `<!DOCTYPE html>
   font: 10px sans-serif;
 }
 
+.node.added circle {
+  fill: #2ca02c;
+}
+
+.node.modified circle {
+  fill: #ff7f0e;
+}
+
+.node.rewired circle {
+  stroke: #d62728;
+  stroke-width: 3px;
+}
+
+.node.grade-a circle {
+  stroke: #2ca02c;
+  stroke-width: 3px;
+}
+
+.node.grade-b circle {
+  stroke: #bcbd22;
+  stroke-width: 3px;
+}
+
+.node.grade-c circle {
+  stroke: #ff7f0e;
+  stroke-width: 3px;
+}
+
+.node.grade-d circle {
+  stroke: #d62728;
+  stroke-width: 3px;
+}
+
+.node.exposed circle {
+  fill: #d62728;
+}
+
+.node.exposed text {
+  fill: #d62728;
+  font-weight: bold;
+}
+
+#snapshot, #report, #rules, #changes, #exposure {
+  font: 12px sans-serif;
+  color: #666;
+}
+
 .link {
   fill: none;
   stroke: #ccc;
@@ -337,9 +719,87 @@ const IndexPage = `<!DOCTYPE html>
 
 </style>
 <body>
+<div id="snapshot"></div>
+<select id="snapshots"></select>
+<details id="report" style="display: none"><summary></summary><ul></ul></details>
+<details id="rules" style="display: none"><summary></summary><ul></ul></details>
+<a id="changes-link">changes since the previous snapshot</a>
+<details id="changes" style="display: none"><summary></summary><ul></ul></details>
+<a id="exposure-link">internet exposure</a>
+<details id="exposure" style="display: none"><summary></summary><ul></ul></details>
 <script src="http://d3js.org/d3.v3.min.js"></script>
 <script>
 
+var query = window.location.search;
+
+d3.select("#changes-link")
+    .attr("href", (query ? query + "&" : "?") + "changes=previous")
+    .style("display", query.indexOf("changes=") >= 0 ? "none" : null);
+
+d3.select("#exposure-link")
+    .attr("href", (query ? query + "&" : "?") + "exposure=true")
+    .style("display", query.indexOf("exposure=") >= 0 ? "none" : null);
+
+d3.json("/snapshots.json", function(error, snapshots) {
+  if (error || !snapshots || snapshots.length == 0) {
+    d3.select("#snapshots").style("display", "none");
+    return;
+  }
+
+  var select = d3.select("#snapshots")
+      .on("change", function() { window.location.search = "?snapshot=" + this.value; });
+
+  select.selectAll("option")
+      .data(snapshots.slice().reverse())
+    .enter().append("option")
+      .attr("value", function(d) { return d.name; })
+      .property("selected", function(d) { return query.indexOf(d.name) >= 0; })
+      .text(function(d) { return d.collected_at; });
+});
+
+d3.json("/snapshot.json" + query, function(error, meta) {
+  if (error || !meta || !meta.source) {
+    d3.select("#snapshot").text("collected: unknown (legacy snapshot)");
+    return;
+  }
+
+  var source = meta.source + (meta.account ? " account " + meta.account : "") + " " + meta.region;
+  d3.select("#snapshot").text("collected " + meta.collected_at + " from " + source + " by awsmap " + meta.awsmap_version);
+});
+
+d3.json("/report.json" + query, function(error, report) {
+  if (error || !report || !report.unresolved || report.unresolved.length == 0) {
+    return;
+  }
+
+  var details = d3.select("#report").style("display", null);
+  details.select("summary").text(report.unresolved.length + " unresolved references");
+  details.select("ul").selectAll("li")
+      .data(report.unresolved)
+    .enter().append("li")
+      .text(function(d) { return d.referrer + " references missing " + d.type + " " + d.reference; });
+});
+
+d3.json("/rules.json" + query, function(error, report) {
+  if (error || !report) {
+    return;
+  }
+
+  var lines = [];
+  report.results.forEach(function(r) {
+    r.violations.forEach(function(v) {
+      lines.push(r.rule + " [" + r.severity + "] " + v.id + ": " + v.message);
+    });
+  });
+
+  var details = d3.select("#rules").style("display", null);
+  details.select("summary").text(report.results.length + " rules, " + lines.length + " violations");
+  details.select("ul").selectAll("li")
+      .data(lines)
+    .enter().append("li")
+      .text(function(d) { return d; });
+});
+
 var width = 960,
     height = 960;
 
@@ -356,7 +816,7 @@ var svg = d3.select("body").append("svg")
   .append("g")
     .attr("transform", "translate(55,0)");
 
-d3.json("/region.json", function(error, root) {
+d3.json("/region.json" + query, function(error, root) {
   var nodes = cluster.nodes(root),
       links = cluster.links(nodes);
 
@@ -375,13 +835,139 @@ d3.json("/region.json", function(error, root) {
   node.append("circle")
       .attr("r", 4.5);
 
+  node.append("title")
+      .text(function(d) {
+        var lines = [d.name];
+        for (var key in d.properties || {}) {
+          lines.push(key + ": " + d.properties[key]);
+        }
+        if (d.sources) {
+          lines.push("collected from " + d.sources.join(", "));
+        }
+        return lines.join("\n");
+      });
+
   node.append("text")
       .attr("dx", function(d) { return d.children ? -8 : 8; })
       .attr("dy", 3)
       .style("text-anchor", function(d) { return d.children ? "end" : "start"; })
       .text(function(d) { return d.name; });
+
+  if (query.indexOf("changes=") >= 0) {
+    showChanges(node);
+  }
+
+  if (query.indexOf("exposure=") >= 0) {
+    showExposure(node);
+  }
+
+  showResilience(node);
 });
 
+// showResilience outlines each ELB in the colour of its resilience grade,
+// A green to D red, adding the grade and findings to its tooltip.
+function showResilience(node) {
+  d3.json("/resilience.json" + query, function(error, report) {
+    if (error || !report) {
+      return;
+    }
+
+    var elbs = {};
+    report.elbs.forEach(function(r) { elbs[r.id] = r; });
+
+    node.each(function(d) {
+      var r = elbs[d.id];
+      if (!r) {
+        return;
+      }
+
+      d3.select(this).classed("grade-" + r.grade.toLowerCase(), true);
+      var title = d3.select(this).select("title");
+      title.text(title.text() + "\nresilience " + r.grade + r.findings.map(function(f) {
+        return "\n" + f.severity + " " + f.check + ": " + f.message;
+      }).join(""));
+    });
+  });
+}
+
+// showExposure marks the instances and ELBs reachable from the internet,
+// adding the open ports and the steps explaining them to their tooltips.
+function showExposure(node) {
+  d3.json("/exposure.json" + query, function(error, report) {
+    var details = d3.select("#exposure").style("display", null);
+    if (error || !report) {
+      details.select("summary").text("no snapshot to analyze");
+      return;
+    }
+
+    var exposures = {}, lines = [];
+    report.exposures.forEach(function(e) {
+      exposures[e.id] = e;
+      if (e.reachable) {
+        lines.push(e.id + " reachable on " + e.ports.map(function(p) {
+          return p.protocol == "icmp" ? "icmp" : p.protocol + " " + (p.from == p.to ? p.from : p.from + "-" + p.to);
+        }).join(", "));
+      }
+    });
+
+    node.classed("exposed", function(d) { return exposures[d.id] && exposures[d.id].reachable; });
+    node.select("title").text(function(d) {
+      var e = exposures[d.id], text = this.textContent;
+      if (!e) {
+        return text;
+      }
+      return text + "\n" + e.steps.map(function(s) {
+        return (s.passed ? "+ " : "- ") + s.check + ": " + s.detail;
+      }).join("\n");
+    });
+
+    details.select("summary").text(lines.length + " of " + report.exposures.length + " reachable from the internet");
+    details.select("ul").selectAll("li")
+        .data(lines)
+      .enter().append("li")
+        .text(function(d) { return d; });
+  });
+}
+
+// showChanges marks the nodes added, modified or rewired since the snapshot
+// given by ?changes= and lists every change, including removed nodes.
+function showChanges(node) {
+  d3.json("/changes.json" + query, function(error, diff) {
+    if (error || !diff) {
+      d3.select("#changes").style("display", null).select("summary").text("no snapshot to compare with");
+      return;
+    }
+
+    var marks = {}, lines = [];
+    (diff.added_nodes || []).forEach(function(n) { marks[n.id] = "added"; lines.push("+ " + n.id); });
+    (diff.removed_nodes || []).forEach(function(n) { lines.push("- " + n.id); });
+    (diff.modified_nodes || []).forEach(function(n) {
+      marks[n.id] = marks[n.id] || "modified";
+      lines.push("~ " + n.id + " " + (n.removed || []).concat(n.added || []).join(", "));
+    });
+    [["+", diff.added_edges], ["-", diff.removed_edges]].forEach(function(change) {
+      (change[1] || []).forEach(function(e) {
+        marks[e.from] = marks[e.from] || "rewired";
+        marks[e.to] = marks[e.to] || "rewired";
+        lines.push(change[0] + " " + e.from + " -[" + e.relationship + "]-> " + e.to);
+      });
+    });
+
+    node.each(function(d) {
+      if (marks[d.id]) {
+        d3.select(this).classed(marks[d.id], true);
+      }
+    });
+
+    var details = d3.select("#changes").style("display", null);
+    details.select("summary").text(lines.length + " changes");
+    details.select("ul").selectAll("li")
+        .data(lines)
+      .enter().append("li")
+        .text(function(d) { return d; });
+  });
+}
+
 d3.select(self.frameElement).style("height", height + "px");
 
 </script>`
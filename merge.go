@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
+
+// resourceId returns the id a resource is keyed by in the graph.
+func resourceId(v interface{}) string {
+	switch r := v.(type) {
+	case *ec2.NetworkACL:
+		return stringValue(r.NetworkACLID)
+	case *ec2.InternetGateway:
+		return stringValue(r.InternetGatewayID)
+	case *ec2.Instance:
+		return stringValue(r.InstanceID)
+	case *elb.LoadBalancerDescription:
+		return stringValue(r.LoadBalancerName)
+	case *ec2.RouteTable:
+		return stringValue(r.RouteTableID)
+	case *ec2.SecurityGroup:
+		return stringValue(r.GroupID)
+	case *ec2.Subnet:
+		return stringValue(r.SubnetID)
+	case *ec2.VPC:
+		return stringValue(r.VPCID)
+	}
+
+	return ""
+}
+
+// snapshotLabel names the source of a snapshot as account/region, falling back to its file name.
+func snapshotLabel(s *Snapshot, filename string) string {
+	if s.Metadata.Account == "" {
+		return filename
+	}
+
+	return s.Metadata.Account + "/" + s.Metadata.Region
+}
+
+var MixedRegions = errors.New("Snapshots of different regions can't be merged!")
+
+// resourceKey identifies a resource across the snapshots of a merge. AWS ids
+// are unique across accounts, ELBs are only named uniquely within one, so
+// their names are qualified with the account when it is known.
+func resourceKey(account string, v interface{}) string {
+	if _, ok := v.(*elb.LoadBalancerDescription); ok && account != "" {
+		return account + "/" + resourceId(v)
+	}
+
+	return resourceId(v)
+}
+
+// MergeSnapshots combines snapshots into one. A resource collected more than
+// once, such as a shared subnet or a VPC seen by both sides of a peering, is
+// kept once using the copy from the most recently collected snapshot. The
+// labels of every snapshot a resource was seen in are recorded in Provenance.
+// ELBs of different accounts never collide, and when several accounts are
+// merged the account of each resource is recorded in Accounts. Identities
+// hold a single region, so snapshots of different regions aren't merged.
+func MergeSnapshots(labels []string, snapshots []*Snapshot) (merged *Snapshot, err error) {
+	merged = &Snapshot{
+		SchemaVersion: SnapshotSchemaVersion,
+		Metadata:      SnapshotMetadata{Source: "merge", AwsmapVersion: Version},
+		Region:        &AwsRegion{},
+		Provenance:    make(map[string][]string),
+	}
+
+	order := make([]int, len(snapshots))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return snapshots[order[a]].Metadata.CollectedAt.After(snapshots[order[b]].Metadata.CollectedAt)
+	})
+
+	accounts := make(map[string]bool)
+	regions := make(map[string]bool)
+	collectors := make(map[string]bool)
+	for i, s := range snapshots {
+		merged.Metadata.Parts = append(merged.Metadata.Parts, s.Metadata)
+		if s.Metadata.Region != "" {
+			regions[s.Metadata.Region] = true
+		}
+		for _, c := range s.Metadata.Collectors {
+			collectors[c] = true
+		}
+
+		at := s.Metadata.CollectedAt
+		if merged.Metadata.CollectedAt.IsZero() || (!at.IsZero() && at.Before(merged.Metadata.CollectedAt)) {
+			merged.Metadata.CollectedAt = at
+		}
+
+		for id, sources := range s.Provenance {
+			merged.Provenance[id] = appendUnique(merged.Provenance[id], sources...)
+		}
+
+		eachResource(s, func(field string, index int, v interface{}) {
+			account := s.account(field, index)
+			accounts[account] = true
+
+			key := resourceKey(account, v)
+			if resourceId(v) != "" && s.Provenance[key] == nil {
+				merged.Provenance[key] = appendUnique(merged.Provenance[key], labels[i])
+			}
+		})
+	}
+
+	if len(accounts) == 1 {
+		for a := range accounts {
+			merged.Metadata.Account = a
+		}
+	}
+
+	if len(regions) > 1 {
+		return nil, MixedRegions
+	}
+
+	for r := range regions {
+		merged.Metadata.Region = r
+	}
+
+	for c := range collectors {
+		merged.Metadata.Collectors = append(merged.Metadata.Collectors, c)
+	}
+	sort.Strings(merged.Metadata.Collectors)
+
+	owners := make(map[string][]string)
+	seen := make(map[string]bool)
+	out := reflect.ValueOf(merged.Region).Elem()
+	for _, i := range order {
+		s := snapshots[i]
+		eachResource(s, func(field string, index int, v interface{}) {
+			account := s.account(field, index)
+			key := field + "/" + resourceKey(account, v)
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+
+			resources := out.FieldByName(field)
+			resources.Set(reflect.Append(resources, reflect.ValueOf(v)))
+			owners[field] = append(owners[field], account)
+		})
+	}
+
+	if len(accounts) > 1 {
+		merged.Accounts = owners
+	}
+
+	return merged, nil
+}
+
+// eachResource calls fn with every resource of s, the region field holding it and its index there.
+func eachResource(s *Snapshot, fn func(field string, index int, v interface{})) {
+	v := reflect.ValueOf(s.Region).Elem()
+	for f := 0; f < v.NumField(); f++ {
+		for r := 0; r < v.Field(f).Len(); r++ {
+			fn(v.Type().Field(f).Name, r, v.Field(f).Index(r).Interface())
+		}
+	}
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, l := range list {
+			if l == v {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// loadMerged loads snapshot files, merging them when there is more than one.
+func loadMerged(files []string) (s *Snapshot, err error) {
+	if len(files) == 1 {
+		return loadSnapshot(files[0])
+	}
+
+	snapshots := make([]*Snapshot, 0, len(files))
+	labels := make([]string, 0, len(files))
+	for _, f := range files {
+		s, err := loadSnapshot(f)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, s)
+		labels = append(labels, snapshotLabel(s, f))
+	}
+
+	return MergeSnapshots(labels, snapshots)
+}
+
+// applyProvenance records on each node the sources its resource was collected from.
+func applyProvenance(graph *Graph, provenance map[string][]string) {
+	if len(provenance) == 0 {
+		return
+	}
+
+	shortIds := graph.ShortIds()
+	for key, sources := range provenance {
+		account, id := "", key
+		if i := strings.LastIndex(key, "/"); i >= 0 {
+			account, id = key[:i], key[i+1:]
+		}
+
+		for _, n := range shortIds[id] {
+			if account == "" || Identity(n.Id).Account() == account {
+				n.Sources = sources
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+import . "."
+
+func Test_MergeSnapshots_should_keep_shared_resources_once_and_record_their_sources(t *testing.T) {
+	a := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "111", "region": "eu-west-1"}, "region": {
+		"Vpcs": [{"VPCID": "vpc-a"}],
+		"Subnets": [{"SubnetID": "subnet-shared", "VPCID": "vpc-a"}]
+	}}`)
+	b := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "222", "region": "eu-west-1"}, "region": {
+		"Vpcs": [{"VPCID": "vpc-b"}],
+		"Subnets": [{"SubnetID": "subnet-shared", "VPCID": "vpc-a"}, {"SubnetID": "subnet-b", "VPCID": "vpc-b"}]
+	}}`)
+
+	merged, err := MergeSnapshots([]string{"a", "b"}, []*Snapshot{a, b})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if len(merged.Region.Vpcs) != 2 || len(merged.Region.Subnets) != 2 {
+		t.Fatalf("merged = %v vpcs, %v subnets, want 2 of each", len(merged.Region.Vpcs), len(merged.Region.Subnets))
+	}
+
+	if sources := strings.Join(merged.Provenance["subnet-shared"], ","); sources != "a,b" {
+		t.Fatalf("sources = %v, want a,b", sources)
+	}
+
+	if merged.Metadata.Region != "eu-west-1" || len(merged.Metadata.Parts) != 2 {
+		t.Fatalf("merged.Metadata = %+v, want the region and both parts", merged.Metadata)
+	}
+
+	var buf bytes.Buffer
+	WriteSnapshot(&buf, merged)
+	s, err := ReadSnapshot(&buf)
+	if err != nil || len(s.Provenance["subnet-b"]) != 1 {
+		t.Fatalf("provenance = %v, want it to round trip", s.Provenance)
+	}
+}
+
+func Test_MergeSnapshots_should_keep_same_named_elbs_of_different_accounts_apart(t *testing.T) {
+	a := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "111", "region": "eu-west-1"}, "region": {
+		"Vpcs": [{"VPCID": "vpc-a"}],
+		"Subnets": [{"SubnetID": "subnet-a", "VPCID": "vpc-a", "AvailabilityZone": "eu-west-1a"}],
+		"LoadBalancers": [{"LoadBalancerName": "api", "Subnets": ["subnet-a"]}]
+	}}`)
+	b := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "222", "region": "eu-west-1"}, "region": {
+		"Vpcs": [{"VPCID": "vpc-b"}],
+		"Subnets": [{"SubnetID": "subnet-a", "VPCID": "vpc-a", "AvailabilityZone": "eu-west-1a"}, {"SubnetID": "subnet-b", "VPCID": "vpc-b", "AvailabilityZone": "eu-west-1b"}],
+		"Instances": [{"InstanceID": "i-b", "SubnetID": "subnet-b", "VPCID": "vpc-b"}],
+		"LoadBalancers": [{"LoadBalancerName": "api", "Subnets": ["subnet-b"], "Instances": [{"InstanceID": "i-b"}]}]
+	}}`)
+
+	merged, err := MergeSnapshots([]string{"a", "b"}, []*Snapshot{a, b})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if len(merged.Region.LoadBalancers) != 2 || len(merged.Region.Subnets) != 2 || merged.Metadata.Account != "" {
+		t.Fatalf("merged = %v elbs, %v subnets, account %q, want both elbs and the shared subnet once",
+			len(merged.Region.LoadBalancers), len(merged.Region.Subnets), merged.Metadata.Account)
+	}
+
+	var buf bytes.Buffer
+	WriteSnapshot(&buf, merged)
+	s, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view := SnapshotView(&Config{Region: "eu-west-1"}, s)
+	for account, subnet := range map[string]string{"111": "subnet-a", "222": "subnet-b"} {
+		n, err := view.Graph.GetNode(account + "/eu-west-1/elb/api")
+		if err != nil || strings.Join(n.Sources, ",") != map[string]string{"111": "a", "222": "b"}[account] {
+			t.Fatalf("elb of %v = %+v, %v, want it sourced from its own snapshot", account, n, err)
+		}
+
+		homes := view.Graph.Query().From(n.Id).Rel(HomedIn).Edges()
+		if len(homes) != 1 || Identity(homes[0].To.Id).Id() != subnet {
+			t.Errorf("elb of %v homed in %v, want %v", account, homes, subnet)
+		}
+	}
+
+	// subnet-a was collected in both accounts but is one resource, in the vpc of 111.
+	if len(view.Report.Unresolved) != 0 {
+		t.Errorf("unresolved = %+v, want none", view.Report.Unresolved)
+	}
+
+	root, err := GenerateDendogram(view.Config, view.Graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tree's ids are the graph's, so the overlays can find its nodes.
+	var proxied []string
+	var walk func(d *Dendogram)
+	walk = func(d *Dendogram) {
+		for _, c := range d.Children {
+			if d.Type == LoadBalancer && c.Type == Instance {
+				proxied = append(proxied, c.Id)
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+
+	if len(proxied) != 1 || proxied[0] != "222/eu-west-1/instance/i-b" {
+		t.Errorf("proxied = %v, want i-b of 222", proxied)
+	}
+}
+
+func Test_MergeSnapshots_should_refuse_snapshots_of_different_regions(t *testing.T) {
+	a := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "111", "region": "eu-west-1"}, "region": {"Vpcs": [{"VPCID": "vpc-a"}]}}`)
+	b := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "111", "region": "us-east-1"}, "region": {"Vpcs": [{"VPCID": "vpc-b"}]}}`)
+
+	if _, err := MergeSnapshots([]string{"a", "b"}, []*Snapshot{a, b}); err != MixedRegions {
+		t.Fatalf("err = %v, want MixedRegions", err)
+	}
+}
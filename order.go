@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+var UnknownOrder = errors.New("Unknown ordering, want id or name!")
+
+// NodeOrder reports whether node a sorts before node b. Graphs are held in
+// maps, so anything iterating them sorts with a NodeOrder to give repeatable output.
+type NodeOrder func(a, b NodeRef) bool
+
+// ByTypeThenId orders nodes by type, then id.
+func ByTypeThenId(a, b NodeRef) bool {
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+
+	return a.Id < b.Id
+}
+
+// ByTypeThenName orders nodes by type, then name, then id. Unnamed nodes sort by their short id.
+func ByTypeThenName(a, b NodeRef) bool {
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+
+	na, nb := nodeName(a), nodeName(b)
+	if na != nb {
+		return na < nb
+	}
+
+	return a.Id < b.Id
+}
+
+func nodeName(n NodeRef) string {
+	if name, ok := n.Properties[NameProperty]; ok {
+		return name
+	}
+
+	return Identity(n.Id).Id()
+}
+
+// NodeOrders are the orderings that can be chosen by name.
+var NodeOrders = map[string]NodeOrder{
+	"id":   ByTypeThenId,
+	"name": ByTypeThenName,
+}
+
+// ParseNodeOrder returns the ordering named name, ByTypeThenId when name is empty.
+func ParseNodeOrder(name string) (NodeOrder, error) {
+	if name == "" {
+		return ByTypeThenId, nil
+	}
+
+	order, ok := NodeOrders[name]
+	if !ok {
+		return nil, UnknownOrder
+	}
+
+	return order, nil
+}
+
+// SortNodes
+func SortNodes(nodes []NodeRef, order NodeOrder) {
+	sort.SliceStable(nodes, func(i, j int) bool { return order(nodes[i], nodes[j]) })
+}
+
+// SortEdges orders edges by their start node, relationship and end node.
+func SortEdges(edges Neighbours, order NodeOrder) {
+	sort.SliceStable(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.From != b.From {
+			return order(a.From, b.From)
+		}
+		if a.Relationship != b.Relationship {
+			return a.Relationship < b.Relationship
+		}
+		return order(a.To, b.To)
+	})
+}
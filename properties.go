@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
+
+// Properties are the normalized attributes of a node's resource, so consumers
+// don't need to know the SDK structs. Tags are held as tag.<key>, a resource's
+// Name tag is also its name. Missing attributes are absent rather than empty.
+type Properties map[string]string
+
+// Property keys shared by several node types.
+const (
+	NameProperty  = "name"
+	CidrProperty  = "cidr"
+	AzProperty    = "az"
+	StateProperty = "state"
+	VpcProperty   = "vpc"
+	TagPrefix     = "tag."
+)
+
+// PublicProperty is set by buildGraph on subnets routing 0.0.0.0/0 to an internet gateway.
+const PublicProperty = "public"
+
+// derivedProperties are worked out from the rest of the snapshot rather
+// than the node's value, so UpdateNodeValue keeps them.
+var derivedProperties = []string{PublicProperty}
+
+// clone returns a copy of p, nil when p is.
+func (p Properties) clone() Properties {
+	if p == nil {
+		return nil
+	}
+
+	c := make(Properties, len(p))
+	for k, v := range p {
+		c[k] = v
+	}
+
+	return c
+}
+
+func (p Properties) set(key string, v *string) {
+	if v != nil && *v != "" {
+		p[key] = *v
+	}
+}
+
+func (p Properties) setBool(key string, v *bool) {
+	if v != nil {
+		p[key] = strconv.FormatBool(*v)
+	}
+}
+
+func (p Properties) setTags(tags []*ec2.Tag) {
+	for _, t := range tags {
+		p.set(TagPrefix+stringValue(t.Key), t.Value)
+	}
+
+	if name, ok := p[TagPrefix+"Name"]; ok {
+		p[NameProperty] = name
+	}
+}
+
+// propertiesOf normalizes a node value.
+func propertiesOf(v interface{}) (p Properties) {
+	p = make(Properties)
+
+	switch r := v.(type) {
+	case string:
+		p[NameProperty] = r
+	case *ec2.VPC:
+		p.set(CidrProperty, r.CIDRBlock)
+		p.set(StateProperty, r.State)
+		p.setBool("default", r.IsDefault)
+		p.setTags(r.Tags)
+	case *ec2.Subnet:
+		p.set(CidrProperty, r.CIDRBlock)
+		p.set(AzProperty, r.AvailabilityZone)
+		p.set(StateProperty, r.State)
+		p.set(VpcProperty, r.VPCID)
+		p.setBool("public_ip_on_launch", r.MapPublicIPOnLaunch)
+		p.setTags(r.Tags)
+	case *ec2.Instance:
+		p.set(VpcProperty, r.VPCID)
+		p.set("subnet", r.SubnetID)
+		p.set("instance_type", r.InstanceType)
+		p.set("private_ip", r.PrivateIPAddress)
+		p.set("public_ip", r.PublicIPAddress)
+		if r.Placement != nil {
+			p.set(AzProperty, r.Placement.AvailabilityZone)
+		}
+		if r.State != nil {
+			p.set(StateProperty, r.State.Name)
+		}
+		p.setTags(r.Tags)
+	case *elb.LoadBalancerDescription:
+		p.set(NameProperty, r.LoadBalancerName)
+		p.set("dns", r.DNSName)
+		p.set("scheme", r.Scheme)
+		p.set(VpcProperty, r.VPCID)
+	case *ec2.RouteTable:
+		p.set(VpcProperty, r.VPCID)
+		p.setTags(r.Tags)
+	case *ec2.InternetGateway:
+		for _, a := range r.Attachments {
+			p.set(VpcProperty, a.VPCID)
+			p.set(StateProperty, a.State)
+		}
+		p.setTags(r.Tags)
+	case *ec2.NetworkACL:
+		p.set(VpcProperty, r.VPCID)
+		p.setBool("default", r.IsDefault)
+		p.setTags(r.Tags)
+	case *ec2.SecurityGroup:
+		p.set(NameProperty, r.GroupName)
+		p.set("description", r.Description)
+		p.set("owner", r.OwnerID)
+		p.set(VpcProperty, r.VPCID)
+		p.setTags(r.Tags)
+	}
+
+	return p
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+/* query language.
+
+A small subset of Cypher over the graph, one path pattern per query:
+
+MATCH (e:elb)-[:proxies]->(i:instance {az: "eu-west-1a"})
+WHERE i.name CONTAINS "api" AND e.id <> "legacy"
+RETURN e, i.private_ip
+LIMIT 10
+
+Node labels are the type names, e.g. vpc, subnet, az, instance, elb, and node
+properties are the short id, the type and the normalized Node.Properties.
+Returning a node returns its identity.
+Relationships may be alternated, -[:proxies|homes]->, reversed, <-[:proxies]-,
+or either direction, -[]-, which matches an edge and its inverse once.
+Comparisons are =, <>, =~ (regexp), CONTAINS, STARTS WITH and ENDS WITH on
+string properties.
+*/
+
+// nodeProperty returns a property of n, its short id and type name are the id and type properties.
+func nodeProperty(n NodeRef, key string) string {
+	switch key {
+	case "id":
+		return Identity(n.Id).Id()
+	case "type":
+		return n.Type.String()
+	}
+
+	return n.Properties[key]
+}
+
+type queryToken struct {
+	kind  rune // 'i' identifier, 's' string, 'n' number, or the punctuation itself
+	text  string
+	upper string
+}
+
+func lexQuery(q string) (tokens []queryToken, err error) {
+	rs := []rune(q)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("query: unterminated string at %d", i)
+			}
+			tokens = append(tokens, queryToken{kind: 's', text: sb.String()})
+			i = j + 1
+		case r == '`':
+			j := i + 1
+			for j < len(rs) && rs[j] != '`' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("query: unterminated identifier at %d", i)
+			}
+			tokens = append(tokens, queryToken{kind: 'i', text: string(rs[i+1 : j])})
+			i = j + 1
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			text := string(rs[i:j])
+			tokens = append(tokens, queryToken{kind: 'i', text: text, upper: strings.ToUpper(text)})
+			i = j
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(rs) && unicode.IsDigit(rs[j]) {
+				j++
+			}
+			tokens = append(tokens, queryToken{kind: 'n', text: string(rs[i:j])})
+			i = j
+		case strings.ContainsRune("()[]{}:,.-<>=|~", r):
+			tokens = append(tokens, queryToken{kind: r, text: string(r)})
+			i++
+		default:
+			return nil, fmt.Errorf("query: unexpected %q at %d", r, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+type nodePattern struct {
+	Var     string
+	Type    Type
+	HasType bool
+	Props   map[string]string
+}
+
+// relPattern directions.
+const (
+	Outgoing = 1
+	Incoming = -1
+	Either   = 0
+)
+
+type relPattern struct {
+	Var       string
+	Rels      []Relationship
+	Direction int
+}
+
+type queryCondition struct {
+	Var, Prop, Op, Value string
+	re                   *regexp.Regexp
+}
+
+type returnItem struct {
+	Var, Prop string
+}
+
+// GraphQuery is a parsed query, see the grammar above.
+type GraphQuery struct {
+	Nodes  []*nodePattern
+	Rels   []*relPattern
+	Where  []*queryCondition
+	Return []returnItem
+	Limit  int
+
+	// MaxSteps bounds the nodes Run visits, 0 for no bound.
+	MaxSteps int
+}
+
+// DefaultMaxSteps bounds the queries run for the server.
+const DefaultMaxSteps = 1000000
+
+// QueryResult holds one row per match with a value for each returned column.
+type QueryResult struct {
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+
+	// Truncated is set when Run stopped at MaxSteps, Rows are then incomplete.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken {
+	if p.pos >= len(p.tokens) {
+		return queryToken{kind: 0}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *queryParser) accept(kind rune) bool {
+	if p.peek().kind == kind {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == 'i' && t.upper == kw {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expect(kind rune) error {
+	if !p.accept(kind) {
+		return p.errorf("want %q", kind)
+	}
+	return nil
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	found := "end of query"
+	if t := p.peek(); t.kind != 0 {
+		found = strconv.Quote(t.text)
+	}
+	return fmt.Errorf("query: "+format+", found %v", append(args, found)...)
+}
+
+func (p *queryParser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != 'i' {
+		return "", p.errorf("want a name")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+// property parses a dotted property key such as az or tag.Name.
+func (p *queryParser) property() (string, error) {
+	key, err := p.ident()
+	for err == nil && p.accept('.') {
+		var part string
+		part, err = p.ident()
+		key += "." + part
+	}
+	return key, err
+}
+
+func (p *queryParser) value() (string, error) {
+	t := p.next()
+	if t.kind != 's' && t.kind != 'n' && t.kind != 'i' {
+		p.pos--
+		return "", p.errorf("want a value")
+	}
+	return t.text, nil
+}
+
+func (p *queryParser) node() (n *nodePattern, err error) {
+	n = &nodePattern{Props: make(map[string]string)}
+	if err = p.expect('('); err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == 'i' {
+		n.Var, _ = p.ident()
+	}
+
+	if p.accept(':') {
+		label, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+
+		n.Type, err = ParseType(strings.ToLower(label))
+		if err != nil {
+			return nil, fmt.Errorf("query: unknown label %v", label)
+		}
+		n.HasType = true
+	}
+
+	if p.accept('{') {
+		for !p.accept('}') {
+			key, err := p.property()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(':'); err != nil {
+				return nil, err
+			}
+			if n.Props[key], err = p.value(); err != nil {
+				return nil, err
+			}
+			p.accept(',')
+		}
+	}
+
+	return n, p.expect(')')
+}
+
+func (p *queryParser) rel() (r *relPattern, err error) {
+	r = &relPattern{Direction: Either}
+	if p.accept('<') {
+		r.Direction = Incoming
+	}
+
+	if err = p.expect('-'); err != nil {
+		return nil, err
+	}
+
+	if p.accept('[') {
+		if p.peek().kind == 'i' {
+			r.Var, _ = p.ident()
+		}
+
+		if p.accept(':') {
+			for {
+				rel, err := p.ident()
+				if err != nil {
+					return nil, err
+				}
+				r.Rels = append(r.Rels, Relationship(rel))
+				if !p.accept('|') {
+					break
+				}
+			}
+		}
+
+		if err = p.expect(']'); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = p.expect('-'); err != nil {
+		return nil, err
+	}
+
+	if p.accept('>') {
+		if r.Direction == Incoming {
+			return nil, p.errorf("relationship can't point both ways")
+		}
+		r.Direction = Outgoing
+	}
+
+	return r, nil
+}
+
+func (p *queryParser) condition() (c *queryCondition, err error) {
+	c = &queryCondition{}
+	if c.Var, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expect('.'); err != nil {
+		return nil, err
+	}
+	if c.Prop, err = p.property(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.accept('='):
+		c.Op = "="
+		if p.accept('~') {
+			c.Op = "=~"
+		}
+	case p.accept('<'):
+		if err = p.expect('>'); err != nil {
+			return nil, err
+		}
+		c.Op = "<>"
+	case p.keyword("CONTAINS"):
+		c.Op = "CONTAINS"
+	case p.keyword("STARTS"), p.keyword("ENDS"):
+		c.Op = p.tokens[p.pos-1].upper + " WITH"
+		if !p.keyword("WITH") {
+			return nil, p.errorf("want WITH")
+		}
+	default:
+		return nil, p.errorf("want a comparison")
+	}
+
+	if c.Value, err = p.value(); err != nil {
+		return nil, err
+	}
+
+	if c.Op == "=~" {
+		c.re, err = regexp.Compile("^(?:" + c.Value + ")$")
+	}
+
+	return c, err
+}
+
+// ParseQuery parses a MATCH ... [WHERE ...] RETURN ... [LIMIT n] query.
+func ParseQuery(q string) (gq *GraphQuery, err error) {
+	tokens, err := lexQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	gq = &GraphQuery{}
+
+	if !p.keyword("MATCH") {
+		return nil, p.errorf("want MATCH")
+	}
+
+	n, err := p.node()
+	if err != nil {
+		return nil, err
+	}
+	gq.Nodes = append(gq.Nodes, n)
+
+	for t := p.peek().kind; t == '-' || t == '<'; t = p.peek().kind {
+		r, err := p.rel()
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := p.node()
+		if err != nil {
+			return nil, err
+		}
+
+		gq.Rels = append(gq.Rels, r)
+		gq.Nodes = append(gq.Nodes, n)
+	}
+
+	if p.keyword("WHERE") {
+		for {
+			c, err := p.condition()
+			if err != nil {
+				return nil, err
+			}
+			gq.Where = append(gq.Where, c)
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+
+	if !p.keyword("RETURN") {
+		return nil, p.errorf("want RETURN")
+	}
+
+	for {
+		var item returnItem
+		if item.Var, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if p.accept('.') {
+			if item.Prop, err = p.property(); err != nil {
+				return nil, err
+			}
+		}
+		gq.Return = append(gq.Return, item)
+		if !p.accept(',') {
+			break
+		}
+	}
+
+	if p.keyword("LIMIT") {
+		t := p.next()
+		if gq.Limit, err = strconv.Atoi(t.text); t.kind != 'n' || err != nil {
+			return nil, fmt.Errorf("query: LIMIT wants a number, found %q", t.text)
+		}
+	}
+
+	if p.peek().kind != 0 {
+		return nil, p.errorf("unexpected input")
+	}
+
+	return gq, gq.check()
+}
+
+// check ensures every variable used in WHERE and RETURN is bound by the pattern.
+func (gq *GraphQuery) check() error {
+	bound := make(map[string]bool)
+	for _, n := range gq.Nodes {
+		bound[n.Var] = n.Var != ""
+	}
+	for _, r := range gq.Rels {
+		bound[r.Var] = r.Var != ""
+	}
+
+	for _, c := range gq.Where {
+		if !bound[c.Var] {
+			return fmt.Errorf("query: %v is not defined", c.Var)
+		}
+	}
+
+	for _, item := range gq.Return {
+		if !bound[item.Var] {
+			return fmt.Errorf("query: %v is not defined", item.Var)
+		}
+	}
+
+	return nil
+}
+
+func (c *queryCondition) holds(v string) bool {
+	switch c.Op {
+	case "=":
+		return v == c.Value
+	case "<>":
+		return v != c.Value
+	case "=~":
+		return c.re.MatchString(v)
+	case "CONTAINS":
+		return strings.Contains(v, c.Value)
+	case "STARTS WITH":
+		return strings.HasPrefix(v, c.Value)
+	case "ENDS WITH":
+		return strings.HasSuffix(v, c.Value)
+	}
+
+	return false
+}
+
+func (np *nodePattern) matches(n NodeRef) bool {
+	if np.HasType && n.Type != np.Type {
+		return false
+	}
+
+	for k, v := range np.Props {
+		if nodeProperty(n, k) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rp *relPattern) allows(rel Relationship) bool {
+	if len(rp.Rels) == 0 {
+		return true
+	}
+
+	for _, r := range rp.Rels {
+		if r == rel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// step is a hop a relationship pattern can take from a node.
+type step struct {
+	edge *Edge
+	to   NodeRef
+}
+
+func (rp *relPattern) steps(g *Graph, from NodeRef) (steps []step) {
+	allows := func(e *Edge) bool {
+		if !rp.allows(e.Relationship) {
+			return false
+		}
+		if rp.Direction != Either {
+			return true
+		}
+		// every edge is stored with its inverse, match only one of the pair.
+		spec, ok := Relationships[e.Relationship]
+		return !ok || !spec.Inverted || !rp.allows(spec.Inverse)
+	}
+
+	if rp.Direction != Incoming {
+		for _, e := range g.Edges[from.Id] {
+			if allows(e) {
+				steps = append(steps, step{e, e.To})
+			}
+		}
+	}
+
+	if rp.Direction != Outgoing {
+		for _, e := range g.incoming[from.Id] {
+			if allows(e) {
+				steps = append(steps, step{e, e.From})
+			}
+		}
+	}
+
+	return steps
+}
+
+// Run matches the query against g. Rows are sorted so results are repeatable,
+// unless MaxSteps truncated them.
+func (gq *GraphQuery) Run(g *Graph) (result *QueryResult) {
+	result = &QueryResult{}
+	for _, item := range gq.Return {
+		col := item.Var
+		if item.Prop != "" {
+			col += "." + item.Prop
+		}
+		result.Columns = append(result.Columns, col)
+	}
+
+	nodes := make(map[string]NodeRef)
+	edges := make(map[string]*Edge)
+
+	bind := func(v string, n NodeRef) (ok, added bool) {
+		if v == "" {
+			return true, false
+		}
+		if bound, ok := nodes[v]; ok {
+			return bound == n, false
+		}
+		nodes[v] = n
+		return true, true
+	}
+
+	emit := func() {
+		for _, c := range gq.Where {
+			v := ""
+			if n, ok := nodes[c.Var]; ok {
+				v = nodeProperty(n, c.Prop)
+			} else if e, ok := edges[c.Var]; ok && c.Prop == "type" {
+				v = string(e.Relationship)
+			}
+			if !c.holds(v) {
+				return
+			}
+		}
+
+		row := make([]string, 0, len(gq.Return))
+		for _, item := range gq.Return {
+			if e, ok := edges[item.Var]; ok {
+				row = append(row, string(e.Relationship))
+			} else if item.Prop != "" {
+				row = append(row, nodeProperty(nodes[item.Var], item.Prop))
+			} else {
+				row = append(row, nodes[item.Var].Id)
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	steps := 0
+	var match func(i int, n NodeRef)
+	match = func(i int, n NodeRef) {
+		if gq.MaxSteps > 0 && steps >= gq.MaxSteps {
+			result.Truncated = true
+			return
+		}
+		steps++
+
+		if !gq.Nodes[i].matches(n) {
+			return
+		}
+
+		ok, added := bind(gq.Nodes[i].Var, n)
+		if !ok {
+			return
+		}
+
+		if i == len(gq.Rels) {
+			emit()
+		} else {
+			rp := gq.Rels[i]
+			for _, s := range rp.steps(g, n) {
+				if rp.Var != "" {
+					edges[rp.Var] = s.edge
+				}
+				match(i+1, s.to)
+			}
+			delete(edges, rp.Var)
+		}
+
+		if added {
+			delete(nodes, gq.Nodes[i].Var)
+		}
+	}
+
+	start := gq.Nodes[0]
+	var candidates []NodeRef
+	if start.HasType {
+		candidates = g.GetNodes(ByType(start.Type))
+	} else {
+		candidates = g.GetNodes()
+	}
+
+	for _, n := range candidates {
+		match(0, n)
+	}
+
+	sort.Sort(byColumns(result.Rows))
+	if gq.Limit > 0 && len(result.Rows) > gq.Limit {
+		result.Rows = result.Rows[:gq.Limit]
+	}
+
+	return result
+}
+
+type byColumns [][]string
+
+func (b byColumns) Len() int      { return len(b) }
+func (b byColumns) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byColumns) Less(i, j int) bool {
+	for c := range b[i] {
+		if b[i][c] != b[j][c] {
+			return b[i][c] < b[j][c]
+		}
+	}
+	return false
+}
+
+// WriteTable writes the result as aligned columns.
+func (qr *QueryResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(qr.Columns, "\t"))
+	for _, row := range qr.Rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	if qr.Truncated {
+		fmt.Fprintln(tw, "(truncated)")
+	}
+
+	return tw.Flush()
+}
+
+// WriteResult writes the result as a table or json.
+func (qr *QueryResult) WriteResult(w io.Writer, format string) error {
+	switch format {
+	case "table", "text":
+		return qr.WriteTable(w)
+	case "json":
+		return json.NewEncoder(w).Encode(qr)
+	}
+
+	return UnknownFormat
+}
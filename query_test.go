@@ -0,0 +1,93 @@
+package main_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+import . "."
+
+func Test_GraphQuery_should_match_typed_nodes_and_properties(t *testing.T) {
+	graph := BuildGraph(&Config{Region: "eu-west-1"}, syntheticRegion(1, 3, 2))
+
+	q, err := ParseQuery(`MATCH (e:elb)-[:proxies]->(i:instance {az: "eu-west-1a"}) RETURN e, i`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := q.Run(graph)
+	expected := [][]string{
+		{"eu-west-1/elb/elb-0-0", "eu-west-1/instance/i-0-0-0"},
+		{"eu-west-1/elb/elb-0-0", "eu-west-1/instance/i-0-0-1"},
+	}
+	if !reflect.DeepEqual(result.Columns, []string{"e", "i"}) || !reflect.DeepEqual(result.Rows, expected) {
+		t.Errorf("got %v %v, want %v", result.Columns, result.Rows, expected)
+	}
+}
+
+func Test_GraphQuery_should_follow_incoming_edges_and_filter_with_where(t *testing.T) {
+	graph := BuildGraph(&Config{Region: "eu-west-1"}, syntheticRegion(1, 3, 2))
+
+	q, err := ParseQuery(`MATCH (i:instance)<-[r:proxies]-(e) WHERE i.tag.Name ENDS WITH "-1" AND e.id =~ "elb-0-[12]" RETURN i.private_ip, r, e LIMIT 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = q.Run(graph).WriteResult(&out, "table")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "i.private_ip  r        e\n10.0.1.1      proxies  eu-west-1/elb/elb-0-1\n"
+	if out.String() != expected {
+		t.Errorf("got\n%v\nwant\n%v", out.String(), expected)
+	}
+}
+
+func Test_ParseQuery_should_reject_invalid_queries(t *testing.T) {
+	for _, q := range []string{
+		`MATCH (e:elb) RETURN x`,
+		`MATCH (e:nope) RETURN e`,
+		`MATCH (e:elb)<-[:proxies]->(i) RETURN e`,
+		`MATCH (e:elb) WHERE e.id = "a`,
+		`RETURN e`,
+	} {
+		if _, err := ParseQuery(q); err == nil {
+			t.Errorf("%v: expected an error", q)
+		}
+	}
+}
+
+func Test_GraphQuery_should_match_either_direction_once(t *testing.T) {
+	graph := BuildGraph(&Config{Region: "eu-west-1"}, syntheticRegion(1, 1, 2))
+
+	q, err := ParseQuery(`MATCH (e:elb)-[r]-(i:instance) RETURN e, r, i`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := q.Run(graph)
+	expected := [][]string{
+		{"eu-west-1/elb/elb-0-0", "proxies", "eu-west-1/instance/i-0-0-0"},
+		{"eu-west-1/elb/elb-0-0", "proxies", "eu-west-1/instance/i-0-0-1"},
+	}
+	if !reflect.DeepEqual(result.Rows, expected) {
+		t.Errorf("got %v, want %v", result.Rows, expected)
+	}
+}
+
+func Test_GraphQuery_should_stop_at_max_steps(t *testing.T) {
+	graph := BuildGraph(&Config{Region: "eu-west-1"}, syntheticRegion(1, 3, 2))
+
+	q, err := ParseQuery(`MATCH (a)-[]-(b)-[]-(c) RETURN a, b, c`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.MaxSteps = 10
+
+	result := q.Run(graph)
+	if !result.Truncated || len(result.Rows) > 10 {
+		t.Errorf("got %d rows, truncated %v, want at most 10 truncated", len(result.Rows), result.Truncated)
+	}
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
+
+/* internet reachability.
+
+For every instance and ELB the analyzer works out what a client anywhere on
+the internet, 0.0.0.0/0, can reach:
+
+	instance: public ip -> subnet route to an igw -> igw attached to the vpc
+	          -> network acl in and out -> security groups
+	elb:      internet-facing -> a subnet routed to an attached igw
+	          -> network acl in and out -> security groups -> listeners
+
+EC2-Classic instances and ELBs live outside any VPC, with no routes or
+network ACLs in the way.
+
+Only rules covering the whole internet, 0.0.0.0/0, open or close a port, a
+narrower rule leaves some of the internet on the other side of it. Network
+ACLs are stateless, so the return traffic must be allowed out on the
+ephemeral ports as well.
+*/
+
+var NoRegionData = errors.New("Needs the snapshot the graph was built from, not a graph json!")
+
+const Internet = "0.0.0.0/0"
+
+// Ephemeral ports replies are sent to, allowed out by network ACLs for return traffic.
+const (
+	EphemeralFrom = 1024
+	EphemeralTo   = 65535
+)
+
+// PortRange is an inclusive range of ports. icmp has no ports and always spans 0-65535.
+type PortRange struct {
+	Protocol string `json:"protocol"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+}
+
+func (pr PortRange) String() string {
+	switch {
+	case pr.Protocol == "icmp":
+		return "icmp"
+	case pr.From == 0 && pr.To == 65535:
+		return pr.Protocol + " all"
+	case pr.From == pr.To:
+		return pr.Protocol + " " + strconv.FormatInt(pr.From, 10)
+	}
+
+	return fmt.Sprintf("%v %d-%d", pr.Protocol, pr.From, pr.To)
+}
+
+var portProtocols = []string{"tcp", "udp", "icmp"}
+
+// portSet holds sorted, non overlapping ranges per protocol.
+type portSet map[string][]PortRange
+
+// normalizeProtocol maps the names and numbers used by security groups and
+// network ACLs to tcp, udp, icmp or all, and anything else to "".
+func normalizeProtocol(p string) string {
+	switch strings.ToLower(p) {
+	case "-1", "all":
+		return "all"
+	case "6", "tcp":
+		return "tcp"
+	case "17", "udp":
+		return "udp"
+	case "1", "icmp":
+		return "icmp"
+	}
+
+	return ""
+}
+
+// add opens from-to for protocol, all opens every protocol. A negative or missing range opens every port.
+func (ps portSet) add(protocol string, from, to *int64) {
+	protocols := []string{protocol}
+	if protocol == "all" {
+		protocols = portProtocols
+	}
+
+	for _, p := range protocols {
+		r := PortRange{p, 0, 65535}
+		if p != "icmp" && from != nil && to != nil && *from >= 0 {
+			r.From, r.To = *from, *to
+		}
+		ps[p] = mergeRanges(append(ps[p], r))
+	}
+}
+
+func (ps portSet) union(other portSet) {
+	for p, ranges := range other {
+		ps[p] = mergeRanges(append(ps[p], ranges...))
+	}
+}
+
+func mergeRanges(ranges []PortRange) (merged []PortRange) {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].From < ranges[j].From })
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.From <= merged[n-1].To+1 {
+			if r.To > merged[n-1].To {
+				merged[n-1].To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+func (ps portSet) intersect(other portSet) (out portSet) {
+	out = make(portSet)
+	for p, ranges := range ps {
+		for _, a := range ranges {
+			for _, b := range other[p] {
+				from, to := a.From, a.To
+				if b.From > from {
+					from = b.From
+				}
+				if b.To < to {
+					to = b.To
+				}
+				if from <= to {
+					out[p] = append(out[p], PortRange{p, from, to})
+				}
+			}
+		}
+		out[p] = mergeRanges(out[p])
+		if len(out[p]) == 0 {
+			delete(out, p)
+		}
+	}
+
+	return out
+}
+
+func (ps portSet) subtract(other portSet) (out portSet) {
+	out = make(portSet)
+	for p, ranges := range ps {
+		for _, r := range ranges {
+			remaining := []PortRange{r}
+			for _, cut := range other[p] {
+				var next []PortRange
+				for _, rem := range remaining {
+					if cut.To < rem.From || cut.From > rem.To {
+						next = append(next, rem)
+						continue
+					}
+					if cut.From > rem.From {
+						next = append(next, PortRange{p, rem.From, cut.From - 1})
+					}
+					if cut.To < rem.To {
+						next = append(next, PortRange{p, cut.To + 1, rem.To})
+					}
+				}
+				remaining = next
+			}
+			out[p] = append(out[p], remaining...)
+		}
+		if len(out[p]) == 0 {
+			delete(out, p)
+		}
+	}
+
+	return out
+}
+
+func (ps portSet) list() (ranges PortRanges) {
+	for _, p := range portProtocols {
+		ranges = append(ranges, ps[p]...)
+	}
+
+	return ranges
+}
+
+func (ps portSet) String() string {
+	return ps.list().String()
+}
+
+// PortRanges
+type PortRanges []PortRange
+
+func (prs PortRanges) String() string {
+	var parts []string
+	for _, r := range prs {
+		parts = append(parts, r.String())
+	}
+
+	if len(parts) == 0 {
+		return "nothing"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// contains reports whether port is in the set, any port for icmp.
+func (ps portSet) contains(protocol string, port int64) bool {
+	for _, r := range ps[protocol] {
+		if protocol == "icmp" || (r.From <= port && port <= r.To) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// repliesAllowed reports whether a stateless ACL direction allowing ports lets replies of protocol through on every ephemeral port.
+// It's conservative: clients pick from narrower ranges, e.g. 32768-60999 on Linux, but which
+// one isn't in the snapshot, so an ACL opening less than EphemeralFrom-EphemeralTo blocks replies.
+func repliesAllowed(ports portSet, protocol string) bool {
+	ephemeral := make(portSet)
+	from, to := int64(EphemeralFrom), int64(EphemeralTo)
+	ephemeral.add(protocol, &from, &to)
+
+	replies := ports.intersect(ephemeral)
+	return len(replies[protocol]) == 1 && replies[protocol][0] == ephemeral[protocol][0]
+}
+
+func allPorts() portSet {
+	ps := make(portSet)
+	ps.add("all", nil, nil)
+	return ps
+}
+
+// ReachabilityStep is one check on the way between two ends.
+type ReachabilityStep struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// Explanation lists the checks made, passed or not, in the order traffic meets them.
+type Explanation []ReachabilityStep
+
+func (ex *Explanation) step(check string, passed bool, format string, args ...interface{}) bool {
+	*ex = append(*ex, ReachabilityStep{check, passed, fmt.Sprintf(format, args...)})
+	return passed
+}
+
+// WriteText writes a step per line, marked + when it passed and - when it didn't.
+func (ex Explanation) WriteText(w io.Writer) (err error) {
+	for _, s := range ex {
+		mark := "-"
+		if s.Passed {
+			mark = "+"
+		}
+		_, err = fmt.Fprintf(w, "  %v %v: %v\n", mark, s.Check, s.Detail)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Exposure is what of a resource the internet can reach, with the steps explaining why.
+type Exposure struct {
+	Id        string      `json:"id"`
+	Type      Type        `json:"type"`
+	Reachable bool        `json:"reachable"`
+	Ports     PortRanges  `json:"ports,omitempty"`
+	Steps     Explanation `json:"steps"`
+}
+
+// ReachabilityReport holds the exposure of every instance and ELB, ordered by type then id.
+type ReachabilityReport struct {
+	Exposures []*Exposure `json:"exposures"`
+}
+
+// Reachable returns the exposures the internet can reach.
+func (rr *ReachabilityReport) Reachable() (exposed []*Exposure) {
+	for _, e := range rr.Exposures {
+		if e.Reachable {
+			exposed = append(exposed, e)
+		}
+	}
+
+	return exposed
+}
+
+// WriteText writes each exposure and its steps, only the reachable ones unless all is set.
+func (rr *ReachabilityReport) WriteText(w io.Writer, all bool) (err error) {
+	for _, e := range rr.Exposures {
+		if !e.Reachable && !all {
+			continue
+		}
+
+		status := "not reachable"
+		if e.Reachable {
+			status = "reachable on " + e.Ports.String()
+		}
+
+		_, err = fmt.Fprintf(w, "%v %v\n", e.Id, status)
+		if err != nil {
+			return err
+		}
+
+		err = e.Steps.WriteText(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "%d of %d reachable from %v\n", len(rr.Reachable()), len(rr.Exposures), Internet)
+
+	return err
+}
+
+// reachability indexes the parts of a region the analysis looks up.
+type reachability struct {
+	config   *Config
+	region   *AwsRegion
+	subnets  map[string]*ec2.Subnet
+	groups   map[string]*ec2.SecurityGroup
+	gateways map[string]*ec2.InternetGateway
+}
+
+func newReachability(config *Config, region *AwsRegion) (r *reachability) {
+	r = &reachability{
+		config:   config,
+		region:   region,
+		subnets:  make(map[string]*ec2.Subnet),
+		groups:   make(map[string]*ec2.SecurityGroup),
+		gateways: make(map[string]*ec2.InternetGateway),
+	}
+
+	for _, sn := range region.Subnets {
+		r.subnets[stringValue(sn.SubnetID)] = sn
+	}
+	for _, sg := range region.SecurityGroups {
+		r.groups[stringValue(sg.GroupID)] = sg
+	}
+	for _, igw := range region.Gateways {
+		r.gateways[stringValue(igw.InternetGatewayID)] = igw
+	}
+
+	return r
+}
+
+// AnalyzeReachability works out the exposure of every instance and ELB in region.
+func AnalyzeReachability(config *Config, region *AwsRegion) (report *ReachabilityReport) {
+	r := newReachability(config, region)
+
+	report = &ReachabilityReport{}
+	for _, i := range region.Instances {
+		report.Exposures = append(report.Exposures, r.instance(i))
+	}
+	for _, lb := range region.LoadBalancers {
+		report.Exposures = append(report.Exposures, r.loadBalancer(lb))
+	}
+
+	sort.SliceStable(report.Exposures, func(i, j int) bool {
+		a, b := report.Exposures[i], report.Exposures[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Id < b.Id
+	})
+
+	return report
+}
+
+// routeTable returns the route table of a subnet, its own or its VPC's main one.
+func (r *reachability) routeTable(subnetId, vpcId string) (main *ec2.RouteTable) {
+	for _, rt := range r.region.Routes {
+		for _, a := range rt.Associations {
+			if a.SubnetID != nil && *a.SubnetID == subnetId {
+				return rt
+			}
+			if a.Main != nil && *a.Main && stringValue(rt.VPCID) == vpcId {
+				main = rt
+			}
+		}
+	}
+
+	return main
+}
+
+// internetRoute returns the internet gateway a route table sends 0.0.0.0/0 to, if any.
+func internetRoute(rt *ec2.RouteTable) (igwId string) {
+	for _, route := range rt.Routes {
+		target := stringValue(route.GatewayID)
+		if stringValue(route.DestinationCIDRBlock) == Internet && strings.HasPrefix(target, "igw-") && stringValue(route.State) != "blackhole" {
+			igwId = target
+		}
+	}
+
+	return igwId
+}
+
+// isPublic reports whether a subnet routes 0.0.0.0/0 to an internet gateway.
+func (r *reachability) isPublic(subnetId, vpcId string) bool {
+	rt := r.routeTable(subnetId, vpcId)
+	return rt != nil && internetRoute(rt) != ""
+}
+
+// internetGateway checks that a subnet routes 0.0.0.0/0 to an internet gateway attached to its VPC.
+func (r *reachability) internetGateway(ex *Explanation, subnetId, vpcId string) bool {
+	rt := r.routeTable(subnetId, vpcId)
+	if rt == nil {
+		return ex.step("route", false, "%v has no route table", subnetId)
+	}
+
+	igwId := internetRoute(rt)
+	if igwId == "" {
+		return ex.step("route", false, "%v of %v has no %v route to an internet gateway", stringValue(rt.RouteTableID), subnetId, Internet)
+	}
+	ex.step("route", true, "%v of %v routes %v to %v", stringValue(rt.RouteTableID), subnetId, Internet, igwId)
+
+	igw, ok := r.gateways[igwId]
+	if !ok {
+		return ex.step("internet gateway", false, "%v is not in the snapshot", igwId)
+	}
+
+	for _, a := range igw.Attachments {
+		state := stringValue(a.State)
+		if stringValue(a.VPCID) == vpcId && (state == "" || state == "available" || state == "attached") {
+			return ex.step("internet gateway", true, "%v is attached to %v", igwId, vpcId)
+		}
+	}
+
+	return ex.step("internet gateway", false, "%v is not attached to %v", igwId, vpcId)
+}
+
+// networkAcl returns the network ACL of a subnet, its own or its VPC's default one.
+func (r *reachability) networkAcl(subnetId, vpcId string) (def *ec2.NetworkACL) {
+	for _, acl := range r.region.Acls {
+		for _, a := range acl.Associations {
+			if a.SubnetID != nil && *a.SubnetID == subnetId {
+				return acl
+			}
+		}
+		if acl.IsDefault != nil && *acl.IsDefault && stringValue(acl.VPCID) == vpcId {
+			def = acl
+		}
+	}
+
+	return def
+}
+
+func isInternet(cidr string) bool {
+	return cidr == Internet
+}
+
+// aclEntries returns the entries of one direction applying to a peer, in rule order.
+func aclEntries(acl *ec2.NetworkACL, egress bool, applies func(cidr string) bool) (entries []*ec2.NetworkACLEntry) {
+	for _, entry := range acl.Entries {
+		if (entry.Egress != nil && *entry.Egress) == egress && applies(stringValue(entry.CIDRBlock)) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return longValue(entries[i].RuleNumber) < longValue(entries[j].RuleNumber) })
+
+	return entries
+}
+
+func entryPorts(entry *ec2.NetworkACLEntry) (ports portSet) {
+	ports = make(portSet)
+	if entry.PortRange != nil {
+		ports.add(normalizeProtocol(stringValue(entry.Protocol)), entry.PortRange.From, entry.PortRange.To)
+	} else {
+		ports.add(normalizeProtocol(stringValue(entry.Protocol)), nil, nil)
+	}
+
+	return ports
+}
+
+// aclPorts evaluates the entries of one direction applying to a peer in rule order, the first rule matching a port decides.
+func aclPorts(acl *ec2.NetworkACL, egress bool, applies func(cidr string) bool) (allowed portSet) {
+	allowed = make(portSet)
+	undecided := allPorts()
+	for _, entry := range aclEntries(acl, egress, applies) {
+		decided := undecided.intersect(entryPorts(entry))
+		if stringValue(entry.RuleAction) == "allow" {
+			allowed.union(decided)
+		}
+		undecided = undecided.subtract(decided)
+	}
+
+	return allowed
+}
+
+// subnetAcl checks the network ACL of a subnet lets the internet in and the replies back out.
+func (r *reachability) subnetAcl(ex *Explanation, subnetId, vpcId string) (allowed portSet) {
+	acl := r.networkAcl(subnetId, vpcId)
+	if acl == nil {
+		// without an ACL in the snapshot there's nothing to say either way.
+		ex.step("network acl", true, "%v has no network acl in the snapshot, assuming the default allow all", subnetId)
+		return allPorts()
+	}
+
+	aclId := stringValue(acl.NetworkACLID)
+	allowed = aclPorts(acl, false, isInternet)
+	ex.step("network acl in", len(allowed) > 0, "%v allows %v in from %v", aclId, allowed, Internet)
+
+	// replies go out from the opened port to an ephemeral port, drop protocols whose replies can't leave.
+	out := aclPorts(acl, true, isInternet)
+	for p := range allowed {
+		if !repliesAllowed(out, p) {
+			delete(allowed, p)
+		}
+	}
+	ex.step("network acl out", len(allowed) > 0, "%v allows replies out for %v", aclId, allowed)
+
+	return allowed
+}
+
+// securityGroups returns the ports a set of security groups open to 0.0.0.0/0.
+func (r *reachability) securityGroups(ex *Explanation, ids []string) (open portSet) {
+	open = make(portSet)
+	var missing, opening []string
+	for _, id := range ids {
+		sg, ok := r.groups[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+
+		before := open.String()
+		for _, p := range sg.IPPermissions {
+			for _, ip := range p.IPRanges {
+				if stringValue(ip.CIDRIP) == Internet {
+					open.add(normalizeProtocol(stringValue(p.IPProtocol)), p.FromPort, p.ToPort)
+				}
+			}
+		}
+		if open.String() != before {
+			opening = append(opening, id)
+		}
+	}
+
+	detail := fmt.Sprintf("%v open %v to %v", strings.Join(opening, ", "), open, Internet)
+	if len(opening) == 0 {
+		detail = fmt.Sprintf("%v open nothing to %v", strings.Join(ids, ", "), Internet)
+	}
+	if len(missing) > 0 {
+		detail += ", " + strings.Join(missing, ", ") + " not in the snapshot"
+	}
+	ex.step("security groups", len(open) > 0, "%v", detail)
+
+	return open
+}
+
+func (r *reachability) instance(i *ec2.Instance) (e *Exposure) {
+	e = &Exposure{Id: resourceNodeId(r.config, Instance, i), Type: Instance}
+	subnetId, vpcId := stringValue(i.SubnetID), stringValue(i.VPCID)
+
+	reachable := e.Steps.step("public ip", i.PublicIPAddress != nil, "%v", publicIpDetail(i))
+
+	var ports portSet
+	if subnetId == "" {
+		// EC2-Classic instances live outside any VPC, a public ip puts them on the internet.
+		e.Steps.step("route", true, "EC2-Classic instance")
+		ports = allPorts()
+	} else {
+		reachable = r.internetGateway(&e.Steps, subnetId, vpcId) && reachable
+		ports = r.subnetAcl(&e.Steps, subnetId, vpcId)
+	}
+
+	var groups []string
+	for _, g := range i.SecurityGroups {
+		groups = append(groups, stringValue(g.GroupID))
+	}
+	ports = ports.intersect(r.securityGroups(&e.Steps, groups))
+
+	if reachable && len(ports) > 0 {
+		e.Reachable, e.Ports = true, ports.list()
+	}
+
+	return e
+}
+
+func publicIpDetail(i *ec2.Instance) string {
+	if i.PublicIPAddress == nil {
+		return "no public or elastic ip"
+	}
+
+	return stringValue(i.PublicIPAddress)
+}
+
+func (r *reachability) loadBalancer(lb *elb.LoadBalancerDescription) (e *Exposure) {
+	e = &Exposure{Id: resourceNodeId(r.config, LoadBalancer, lb), Type: LoadBalancer}
+
+	scheme := stringValue(lb.Scheme)
+	if scheme == "" {
+		scheme = "internet-facing"
+	}
+	reachable := e.Steps.step("scheme", scheme != "internal", "%v", scheme)
+
+	// the ELB is reachable through any subnet routed to the internet, each subnet's ACL applies to its nodes.
+	ports := make(portSet)
+	public := false
+	for _, s := range lb.Subnets {
+		vpcId := stringValue(lb.VPCID)
+		if sn, ok := r.subnets[stringValue(s)]; ok && vpcId == "" {
+			vpcId = stringValue(sn.VPCID)
+		}
+
+		if r.internetGateway(&e.Steps, stringValue(s), vpcId) {
+			public = true
+			ports.union(r.subnetAcl(&e.Steps, stringValue(s), vpcId))
+		}
+	}
+
+	if len(lb.Subnets) == 0 {
+		// EC2-Classic ELBs live outside any VPC and are always on the internet.
+		e.Steps.step("route", true, "EC2-Classic load balancer")
+		public, ports = true, allPorts()
+	}
+
+	if len(lb.SecurityGroups) > 0 {
+		var groups []string
+		for _, g := range lb.SecurityGroups {
+			groups = append(groups, stringValue(g))
+		}
+		ports = ports.intersect(r.securityGroups(&e.Steps, groups))
+	}
+
+	listeners := make(portSet)
+	for _, ld := range lb.ListenerDescriptions {
+		if ld.Listener != nil {
+			listeners.add("tcp", ld.Listener.LoadBalancerPort, ld.Listener.LoadBalancerPort)
+		}
+	}
+	ports = ports.intersect(listeners)
+	e.Steps.step("listeners", len(ports) > 0, "listening on %v, %v of it open", listeners, ports)
+
+	if reachable && public && len(ports) > 0 {
+		e.Reachable, e.Ports = true, ports.list()
+	}
+
+	return e
+}
+
+func longValue(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+
+	return *v
+}
@@ -0,0 +1,110 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+import . "."
+
+const reachabilityRegion = `{
+	"Vpcs": [{"VPCID": "vpc-1"}],
+	"Subnets": [{"SubnetID": "subnet-public", "VPCID": "vpc-1"}, {"SubnetID": "subnet-private", "VPCID": "vpc-1"}],
+	"Gateways": [{"InternetGatewayID": "igw-1", "Attachments": [{"VPCID": "vpc-1", "State": "available"}]}],
+	"Routes": [
+		{"RouteTableID": "rtb-main", "VPCID": "vpc-1", "Associations": [{"Main": true}], "Routes": [{"DestinationCIDRBlock": "10.0.0.0/16", "GatewayID": "local"}]},
+		{"RouteTableID": "rtb-public", "VPCID": "vpc-1", "Associations": [{"SubnetID": "subnet-public"}], "Routes": [{"DestinationCIDRBlock": "0.0.0.0/0", "GatewayID": "igw-1"}]}
+	],
+	"Acls": [{"NetworkACLID": "acl-1", "VPCID": "vpc-1", "IsDefault": true, "Entries": [
+		{"RuleNumber": 100, "Protocol": "6", "RuleAction": "deny", "Egress": false, "CIDRBlock": "0.0.0.0/0", "PortRange": {"From": 22, "To": 22}},
+		{"RuleNumber": 200, "Protocol": "-1", "RuleAction": "allow", "Egress": false, "CIDRBlock": "0.0.0.0/0"},
+		{"RuleNumber": 100, "Protocol": "-1", "RuleAction": "allow", "Egress": true, "CIDRBlock": "0.0.0.0/0"}
+	]}],
+	"SecurityGroups": [{"GroupID": "sg-web", "IPPermissions": [
+		{"IPProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IPRanges": [{"CIDRIP": "0.0.0.0/0"}]},
+		{"IPProtocol": "tcp", "FromPort": 80, "ToPort": 443, "IPRanges": [{"CIDRIP": "0.0.0.0/0"}]},
+		{"IPProtocol": "tcp", "FromPort": 5432, "ToPort": 5432, "IPRanges": [{"CIDRIP": "10.0.0.0/16"}]}
+	]}],
+	"Instances": [
+		{"InstanceID": "i-web", "SubnetID": "subnet-public", "VPCID": "vpc-1", "PublicIPAddress": "54.0.0.1", "SecurityGroups": [{"GroupID": "sg-web"}]},
+		{"InstanceID": "i-db", "SubnetID": "subnet-private", "VPCID": "vpc-1", "PublicIPAddress": "54.0.0.2", "SecurityGroups": [{"GroupID": "sg-web"}]},
+		{"InstanceID": "i-worker", "SubnetID": "subnet-public", "VPCID": "vpc-1", "SecurityGroups": [{"GroupID": "sg-web"}]}
+	],
+	"LoadBalancers": [{"LoadBalancerName": "web", "Scheme": "internet-facing", "VPCID": "vpc-1", "Subnets": ["subnet-public"], "SecurityGroups": ["sg-web"],
+		"ListenerDescriptions": [{"Listener": {"LoadBalancerPort": 443, "Protocol": "HTTPS"}}, {"Listener": {"LoadBalancerPort": 8080, "Protocol": "HTTP"}}]}]
+}`
+
+func Test_AnalyzeReachability_should_intersect_every_step(t *testing.T) {
+	region := snapshotFrom(t, reachabilityRegion).Region
+	report := AnalyzeReachability(&Config{Region: "eu-west-1"}, region)
+
+	got := make(map[string]string)
+	for _, e := range report.Exposures {
+		got[Identity(e.Id).Id()] = "not reachable"
+		if e.Reachable {
+			got[Identity(e.Id).Id()] = e.Ports.String()
+		}
+	}
+
+	want := map[string]string{
+		// tcp 22 is open in the security group but denied by the ACL first.
+		"i-web": "tcp 80-443",
+		// the private subnet only has the main route table, without a route to igw-1.
+		"i-db":     "not reachable",
+		"i-worker": "not reachable",
+		// 8080 is listened on but not open in the security group.
+		"web": "tcp 443",
+	}
+
+	for id, ports := range want {
+		if got[id] != ports {
+			t.Errorf("%v = %v, want %v", id, got[id], ports)
+		}
+	}
+
+	var buf bytes.Buffer
+	report.WriteText(&buf, true)
+	for _, line := range []string{
+		"- route: rtb-main of subnet-private has no 0.0.0.0/0 route to an internet gateway",
+		"- public ip: no public or elastic ip",
+		"+ network acl in: acl-1 allows tcp 0-21, tcp 23-65535, udp all, icmp in from 0.0.0.0/0",
+		"2 of 4 reachable from 0.0.0.0/0",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("explanation missing %q:\n%v", line, buf.String())
+		}
+	}
+}
+
+func Test_AnalyzeReachability_should_need_replies_allowed_out(t *testing.T) {
+	region := snapshotFrom(t, strings.Replace(reachabilityRegion,
+		`{"RuleNumber": 100, "Protocol": "-1", "RuleAction": "allow", "Egress": true, "CIDRBlock": "0.0.0.0/0"}`,
+		`{"RuleNumber": 100, "Protocol": "6", "RuleAction": "allow", "Egress": true, "CIDRBlock": "0.0.0.0/0", "PortRange": {"From": 443, "To": 443}}`, 1)).Region
+	report := AnalyzeReachability(&Config{Region: "eu-west-1"}, region)
+
+	if exposed := report.Reachable(); len(exposed) != 0 {
+		t.Fatalf("report.Reachable() = %v, want none with replies blocked", exposed)
+	}
+}
+
+func Test_AnalyzeReachability_should_need_only_a_public_ip_for_ec2_classic(t *testing.T) {
+	region := snapshotFrom(t, strings.Replace(reachabilityRegion,
+		`"Instances": [`,
+		`"Instances": [
+		{"InstanceID": "i-classic", "PublicIPAddress": "54.0.0.3", "SecurityGroups": [{"GroupID": "sg-web"}]},`, 1)).Region
+	report := AnalyzeReachability(&Config{Region: "eu-west-1"}, region)
+
+	for _, e := range report.Exposures {
+		if Identity(e.Id).Id() != "i-classic" {
+			continue
+		}
+
+		// without a subnet neither the main route table nor the default ACL applies.
+		if !e.Reachable || e.Ports.String() != "tcp 22, tcp 80-443" || len(e.Steps) != 3 || e.Steps[1].Detail != "EC2-Classic instance" {
+			t.Errorf("exposure = %+v, want tcp 22 and 80-443 reachable past the public ip", e)
+		}
+		return
+	}
+
+	t.Errorf("no exposure for i-classic in %v", report.Exposures)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+)
+
+// Redactor pseudonymizes a snapshot so it can be shared. Every replacement is
+// derived from Key with an HMAC, so the same input always maps to the same
+// output and references between resources survive, while the originals can't
+// be recovered without the key.
+//
+// Tag values are replaced unless their key is in KeepTags, and tags whose key
+// is in DropTags are removed.
+type Redactor struct {
+	Key      []byte
+	DropTags map[string]bool
+	KeepTags map[string]bool
+
+	prefixBits map[string]byte
+}
+
+// NewRedactor
+func NewRedactor(key []byte, dropTags, keepTags []string) (r *Redactor) {
+	r = &Redactor{
+		Key:        key,
+		DropTags:   make(map[string]bool),
+		KeepTags:   make(map[string]bool),
+		prefixBits: make(map[string]byte),
+	}
+
+	for _, k := range dropTags {
+		r.DropTags[k] = true
+	}
+
+	for _, k := range keepTags {
+		r.KeepTags[k] = true
+	}
+
+	return r
+}
+
+func (r *Redactor) sum(kind, v string) []byte {
+	mac := hmac.New(sha256.New, r.Key)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(v))
+
+	return mac.Sum(nil)
+}
+
+func (r *Redactor) hex(kind, v string, n int) string {
+	return hex.EncodeToString(r.sum(kind, v))[:n]
+}
+
+// Identifier replaces the part of an AWS id after its type prefix, so an i- id stays an i- id.
+// The local route target is not an id and is kept.
+func (r *Redactor) Identifier(id string) string {
+	if id == "" || id == "local" {
+		return id
+	}
+
+	i := strings.LastIndex(id, "-")
+	prefix, suffix := id[:i+1], id[i+1:]
+	n := len(suffix)
+	if n < 8 {
+		n = 8
+	}
+
+	return prefix + r.hex("id", id, n)
+}
+
+// Name replaces a user chosen name such as an ELB or security group name.
+func (r *Redactor) Name(name string) string {
+	if name == "" {
+		return name
+	}
+
+	return "name-" + r.hex("name", name, 12)
+}
+
+// Account replaces a 12 digit AWS account id with another 12 digit id.
+func (r *Redactor) Account(account string) string {
+	if account == "" {
+		return account
+	}
+
+	return fmt.Sprintf("%012d", binary.BigEndian.Uint64(r.sum("account", account))%1000000000000)
+}
+
+// ARN replaces the account and resource of an ARN, keeping its partition,
+// service and region, e.g. arn:aws:iam::123456789012:instance-profile/web.
+// A value that isn't an ARN is replaced as a name.
+func (r *Redactor) ARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return r.Name(arn)
+	}
+
+	parts[4] = r.Account(parts[4])
+	parts[5] = "resource-" + r.hex("resource", parts[5], 16)
+
+	return strings.Join(parts, ":")
+}
+
+// Hostname replaces the first label of a DNS name, which is where AWS encodes
+// IPs and ELB names, keeping the region and service domain.
+func (r *Redactor) Hostname(host string) string {
+	if host == "" {
+		return host
+	}
+
+	labels := strings.SplitN(host, ".", 2)
+	labels[0] = "host-" + r.hex("host", labels[0], 12)
+
+	return strings.Join(labels, ".")
+}
+
+// IP maps an address with a prefix preserving permutation: addresses sharing
+// an n bit prefix still share an n bit prefix afterwards, so subnets stay
+// inside their VPC and instances inside their subnet.
+func (r *Redactor) IP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	out := make(net.IP, len(ip))
+	prefix := make([]byte, 0, len(ip)*8)
+	for i := 0; i < len(ip)*8; i++ {
+		shift := uint(7 - i%8)
+		bit := (ip[i/8] >> shift) & 1
+		out[i/8] |= (bit ^ r.prefixBit(prefix)) << shift
+		prefix = append(prefix, '0'+bit)
+	}
+
+	return out
+}
+
+func (r *Redactor) prefixBit(prefix []byte) byte {
+	bit, ok := r.prefixBits[string(prefix)]
+	if !ok {
+		bit = r.sum("ip", string(prefix))[0] & 1
+		r.prefixBits[string(prefix)] = bit
+	}
+
+	return bit
+}
+
+// CIDR masks an address or CIDR block, keeping the prefix length. 0.0.0.0/0 is unchanged.
+func (r *Redactor) CIDR(cidr string) string {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return r.Name(cidr)
+		}
+		return r.IP(ip).String()
+	}
+
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return r.Name(cidr)
+	}
+
+	ones, _ := ipnet.Mask.Size()
+	if ones == 0 {
+		return cidr
+	}
+
+	masked := r.IP(ipnet.IP).Mask(ipnet.Mask)
+
+	return fmt.Sprintf("%v/%d", masked, ones)
+}
+
+// Tags drops, keeps or replaces each tag according to the redactor's tag keys.
+func (r *Redactor) Tags(tags []*ec2.Tag) (redacted []*ec2.Tag) {
+	for _, t := range tags {
+		key := stringValue(t.Key)
+		if r.DropTags[key] {
+			continue
+		}
+
+		if !r.KeepTags[key] && t.Value != nil {
+			v := "tag-" + r.hex("tag", key+"="+*t.Value, 12)
+			t.Value = &v
+		}
+
+		redacted = append(redacted, t)
+	}
+
+	return redacted
+}
+
+// redactedNames are the user chosen names that are replaced. Other fields
+// ending in Name, such as InstanceState.Name, hold AWS enums and are kept.
+var redactedNames = map[string]bool{
+	"GroupName":        true,
+	"KeyName":          true,
+	"LoadBalancerName": true,
+	"Description":      true,
+}
+
+// redactedIdLists are the string lists holding resource ids.
+var redactedIdLists = map[string]bool{
+	"SecurityGroups": true,
+	"Subnets":        true,
+}
+
+// redactString picks a replacement for a string field based on its name,
+// replaces an ARN in any field, such as Listener.SSLCertificateID, and masks
+// any other field holding an IP address, such as PublicIP.
+func (r *Redactor) redactString(field, v string) string {
+	switch {
+	case strings.HasPrefix(v, "arn:"):
+		return r.ARN(v)
+	case field == "OwnerID" || field == "UserID" || field == "InstanceOwnerID" || field == "IPOwnerID":
+		return r.Account(v)
+	case field == "OwnerAlias":
+		// an ELB's source group is owned by an account, or by amazon-elb.
+		if strings.Trim(v, "0123456789") == "" {
+			return r.Account(v)
+		}
+		return v
+	case field == "CIDRIP" || strings.HasSuffix(field, "CIDRBlock") || strings.HasSuffix(field, "IPAddress"):
+		return r.CIDR(v)
+	case strings.HasSuffix(field, "DNSName") || field == "CanonicalHostedZoneName":
+		return r.Hostname(v)
+	case strings.HasSuffix(field, "ARN"):
+		return r.ARN(v)
+	case strings.HasSuffix(field, "ID") || redactedIdLists[field]:
+		return r.Identifier(v)
+	case redactedNames[field]:
+		return r.Name(v)
+	case net.ParseIP(v) != nil:
+		return r.CIDR(v)
+	}
+
+	return v
+}
+
+// redactValue walks v replacing sensitive string fields in place.
+func (r *Redactor) redactValue(field string, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		// strings are replaced rather than updated, a *string may be shared between fields.
+		if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.String && v.CanSet() {
+			s := r.redactString(field, v.Elem().String())
+			v.Set(reflect.ValueOf(&s))
+			return
+		}
+
+		if !v.IsNil() {
+			r.redactValue(field, v.Elem())
+		}
+	case reflect.Slice:
+		if tags, ok := v.Interface().([]*ec2.Tag); ok {
+			v.Set(reflect.ValueOf(r.Tags(tags)))
+			return
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			r.redactValue(field, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				r.redactValue(v.Type().Field(i).Name, v.Field(i))
+			}
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(r.redactString(field, v.String()))
+		}
+	}
+}
+
+// RedactRegion pseudonymizes region in place.
+func (r *Redactor) RedactRegion(region *AwsRegion) {
+	r.redactValue("", reflect.ValueOf(region))
+}
+
+// RedactSnapshot pseudonymizes s, its metadata and the provenance of a merged snapshot in place.
+func (r *Redactor) RedactSnapshot(s *Snapshot) {
+	keys := make(map[interface{}]string)
+	eachResource(s, func(field string, index int, v interface{}) {
+		keys[v] = resourceKey(s.account(field, index), v)
+	})
+
+	r.RedactRegion(s.Region)
+	r.redactMetadata(&s.Metadata)
+	for _, accounts := range s.Accounts {
+		for i := range accounts {
+			accounts[i] = r.Account(accounts[i])
+		}
+	}
+
+	if s.Provenance == nil {
+		return
+	}
+
+	provenance := make(map[string][]string, len(s.Provenance))
+	eachResource(s, func(field string, index int, v interface{}) {
+		sources, ok := s.Provenance[keys[v]]
+		if !ok {
+			return
+		}
+
+		redacted := make([]string, len(sources))
+		for i, label := range sources {
+			redacted[i] = r.label(label)
+		}
+		provenance[resourceKey(s.account(field, index), v)] = redacted
+	})
+	s.Provenance = provenance
+}
+
+func (r *Redactor) redactMetadata(m *SnapshotMetadata) {
+	m.Account = r.Account(m.Account)
+	m.Redacted = true
+	if strings.Contains(m.Source, ":") {
+		m.Source = strings.SplitN(m.Source, ":", 2)[0]
+	}
+
+	for i := range m.Parts {
+		r.redactMetadata(&m.Parts[i])
+	}
+}
+
+// label replaces a merge source, account/region or a file name, see snapshotLabel.
+func (r *Redactor) label(label string) string {
+	if i := strings.Index(label, "/"); i > 0 && strings.Trim(label[:i], "0123456789") == "" {
+		return r.Account(label[:i]) + label[i:]
+	}
+
+	return r.Name(label)
+}
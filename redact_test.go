@@ -0,0 +1,155 @@
+package main_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+import . "."
+
+func Test_Redactor_should_pseudonymize_consistently(t *testing.T) {
+	r := NewRedactor([]byte("secret"), nil, nil)
+
+	if r.Identifier("i-0123456789abcdef0") != r.Identifier("i-0123456789abcdef0") {
+		t.Fatal("same id mapped to different pseudonyms")
+	}
+
+	if id := r.Identifier("i-0123456789abcdef0"); id[:2] != "i-" || id == "i-0123456789abcdef0" {
+		t.Fatalf("id = %v, want a different i- id", id)
+	}
+
+	if a := r.Account("123456789012"); len(a) != 12 || a == "123456789012" {
+		t.Fatalf("account = %v, want a different 12 digit account", a)
+	}
+}
+
+func Test_Redactor_CIDR_should_keep_the_network_structure(t *testing.T) {
+	r := NewRedactor([]byte("secret"), nil, nil)
+
+	_, vpc, _ := net.ParseCIDR(r.CIDR("10.1.0.0/16"))
+	_, subnet, _ := net.ParseCIDR(r.CIDR("10.1.2.0/24"))
+	ip := net.ParseIP(r.CIDR("10.1.2.3"))
+
+	if !vpc.Contains(subnet.IP) || !subnet.Contains(ip) {
+		t.Fatalf("vpc %v, subnet %v, ip %v, want nested", vpc, subnet, ip)
+	}
+
+	if vpc.String() == "10.1.0.0/16" {
+		t.Fatal("vpc cidr was not masked")
+	}
+
+	if r.CIDR("0.0.0.0/0") != "0.0.0.0/0" {
+		t.Fatalf("0.0.0.0/0 = %v, want unchanged", r.CIDR("0.0.0.0/0"))
+	}
+}
+
+func Test_Redactor_RedactSnapshot_should_keep_the_graph_intact(t *testing.T) {
+	s := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "123456789012"}, "region": {
+		"Vpcs": [{"VPCID": "vpc-1"}],
+		"Subnets": [{"SubnetID": "subnet-1", "VPCID": "vpc-1", "AvailabilityZone": "eu-west-1a"}],
+		"Instances": [{"InstanceID": "i-1", "SubnetID": "subnet-1", "PublicIPAddress": "54.1.2.3",
+			"State": {"Name": "running"},
+			"Tags": [{"Key": "Name", "Value": "db"}, {"Key": "Secret", "Value": "x"}, {"Key": "env", "Value": "prod"}]}],
+		"LoadBalancers": [{"LoadBalancerName": "web", "Subnets": ["subnet-1"], "Instances": [{"InstanceID": "i-1"}]}]
+	}}`)
+
+	NewRedactor([]byte("secret"), []string{"Secret"}, []string{"env"}).RedactSnapshot(s)
+
+	i := s.Region.Instances[0]
+	if *i.InstanceID == "i-1" || *i.PublicIPAddress == "54.1.2.3" || *i.State.Name != "running" {
+		t.Fatalf("instance = %+v, want id and ip redacted, state kept", i)
+	}
+
+	if len(i.Tags) != 2 || *i.Tags[0].Value == "db" || *i.Tags[1].Value != "prod" {
+		t.Fatalf("tags = %v, want Secret dropped, Name hashed and env kept", i.Tags)
+	}
+
+	if !s.Metadata.Redacted || s.Metadata.Account == "123456789012" {
+		t.Fatalf("metadata = %+v, want redacted", s.Metadata)
+	}
+
+	graph := BuildGraph(&Config{Region: "eu-west-1"}, s.Region)
+	lb, err := graph.Lookup(*s.Region.LoadBalancers[0].LoadBalancerName)
+	if err != nil {
+		t.Fatalf("err = %v, want the elb", err)
+	}
+
+	neighbours, err := graph.GetNeighbours(lb.Id)
+	if err != nil || len(neighbours) != 2 {
+		t.Fatalf("len(neighbours) = %v, want the elb linked to its subnet and instance", len(neighbours))
+	}
+}
+
+func Test_Redactor_RedactSnapshot_should_hide_arns_and_ips_in_any_field(t *testing.T) {
+	s := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "123456789012"}, "region": {
+		"Instances": [{"InstanceID": "i-1",
+			"IAMInstanceProfile": {"ARN": "arn:aws:iam::123456789012:instance-profile/billing-api-prod", "ID": "AIPAJ2EXAMPLE"},
+			"NetworkInterfaces": [{"Association": {"PublicIP": "54.1.2.3", "IPOwnerID": "123456789012"}}]}]
+	}}`)
+
+	NewRedactor([]byte("secret"), nil, nil).RedactSnapshot(s)
+
+	i := s.Region.Instances[0]
+	arn := *i.IAMInstanceProfile.ARN
+	if !strings.HasPrefix(arn, "arn:aws:iam::") || strings.Contains(arn, "123456789012") || strings.Contains(arn, "billing") {
+		t.Errorf("arn = %v, want the account and resource replaced", arn)
+	}
+
+	association := i.NetworkInterfaces[0].Association
+	if net.ParseIP(*association.PublicIP) == nil || *association.PublicIP == "54.1.2.3" || *association.IPOwnerID == "123456789012" {
+		t.Errorf("association = %v, %v, want the ip masked and the owner replaced", *association.PublicIP, *association.IPOwnerID)
+	}
+}
+
+func Test_Redactor_RedactSnapshot_should_hide_arns_whatever_the_field(t *testing.T) {
+	s := snapshotFrom(t, `{"schema_version": 1, "region": {
+		"LoadBalancers": [{"LoadBalancerName": "web",
+			"ListenerDescriptions": [{"Listener": {"LoadBalancerPort": 443, "Protocol": "HTTPS",
+				"SSLCertificateID": "arn:aws:acm:eu-west-1:123456789012:certificate/0f1e2d3c"}}],
+			"SourceSecurityGroup": {"GroupName": "web-elb", "OwnerAlias": "123456789012"}}]
+	}}`)
+
+	NewRedactor([]byte("secret"), nil, nil).RedactSnapshot(s)
+
+	lb := s.Region.LoadBalancers[0]
+	cert := *lb.ListenerDescriptions[0].Listener.SSLCertificateID
+	if !strings.HasPrefix(cert, "arn:aws:acm:eu-west-1:") || strings.Contains(cert, "123456789012") || strings.Contains(cert, "0f1e2d3c") {
+		t.Errorf("certificate = %v, want the account and resource replaced", cert)
+	}
+
+	if owner := *lb.SourceSecurityGroup.OwnerAlias; owner == "123456789012" || strings.Trim(owner, "0123456789") != "" {
+		t.Errorf("owner alias = %v, want another account", owner)
+	}
+}
+
+func Test_Redactor_RedactSnapshot_should_redact_the_provenance_of_a_merge(t *testing.T) {
+	a := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "111111111111", "region": "eu-west-1"}, "region": {"LoadBalancers": [{"LoadBalancerName": "api"}]}}`)
+	b := snapshotFrom(t, `{"schema_version": 1, "metadata": {"account": "222222222222", "region": "eu-west-1"}, "region": {"LoadBalancers": [{"LoadBalancerName": "api"}]}}`)
+	s, err := MergeSnapshots([]string{"111111111111/eu-west-1", "222222222222/eu-west-1"}, []*Snapshot{a, b})
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	NewRedactor([]byte("secret"), nil, nil).RedactSnapshot(s)
+
+	for key, sources := range s.Provenance {
+		if strings.Contains(key, "api") || strings.Contains(key, "111111111111") || strings.Contains(sources[0], "111111111111") {
+			t.Errorf("provenance %v = %v, want it redacted", key, sources)
+		}
+	}
+
+	elbs := SnapshotView(&Config{Region: "eu-west-1"}, s).Graph.GetNodes(ByType(LoadBalancer))
+	if len(elbs) != 2 {
+		t.Fatalf("elbs = %v, want one per account", elbs)
+	}
+
+	for _, n := range elbs {
+		if len(n.Sources) != 1 || !strings.HasPrefix(n.Sources[0], Identity(n.Id).Account()+"/") {
+			t.Errorf("%v sources = %v, want its own redacted account", n.Id, n.Sources)
+		}
+	}
+
+	if len(s.Metadata.Parts) != 2 || s.Metadata.Parts[0].Account == "111111111111" {
+		t.Errorf("parts = %+v, want their accounts redacted", s.Metadata.Parts)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// UnresolvedReference is a reference from a resource to one missing from the
+// snapshot, such as an instance in a deleted subnet or an ELB proxying an
+// instance in another account. Reference is empty when the resource has none,
+// such as an EC2-Classic instance without a subnet.
+type UnresolvedReference struct {
+	Referrer     string       `json:"referrer"`
+	Relationship Relationship `json:"relationship"`
+	Type         Type         `json:"type"`
+	Reference    string       `json:"reference"`
+	Placeholder  bool         `json:"placeholder"`
+}
+
+// BuildReport describes the problems found building a graph.
+type BuildReport struct {
+	Unresolved []UnresolvedReference `json:"unresolved"`
+}
+
+// HasProblems
+func (br *BuildReport) HasProblems() bool {
+	return len(br.Unresolved) > 0
+}
+
+func (br *BuildReport) sort() {
+	sort.Slice(br.Unresolved, func(a, b int) bool {
+		ua, ub := br.Unresolved[a], br.Unresolved[b]
+		if ua.Referrer != ub.Referrer {
+			return ua.Referrer < ub.Referrer
+		}
+		if ua.Reference != ub.Reference {
+			return ua.Reference < ub.Reference
+		}
+		return ua.Relationship < ub.Relationship
+	})
+}
+
+// WriteText writes one line per unresolved reference.
+func (br *BuildReport) WriteText(w io.Writer) (err error) {
+	for _, u := range br.Unresolved {
+		placeholder := ""
+		if u.Placeholder {
+			placeholder = " (placeholder)"
+		}
+
+		reference := u.Reference
+		if reference == "" {
+			reference = "(none)"
+		}
+
+		_, err = fmt.Fprintf(w, "%v -[%v]- missing %v %v%v\n", u.Referrer, u.Relationship, u.Type, reference, placeholder)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "%d unresolved references\n", len(br.Unresolved))
+
+	return err
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+/* load balancer resilience.
+
+For every ELB, from the instances it proxies and the subnets it's homed in:
+
+	single-az         high    its healthy instances sit in one AZ, or there are none
+	shared-subnet     medium  all its instances share one subnet
+	empty-az          medium  it's homed in an AZ without a healthy instance
+	az-imbalance      low     the busiest AZ has more than the threshold more instances than the quietest
+
+An instance is healthy when it's running, the ELB's own health checks
+aren't collected. The grade is A without findings, then B, C and D by the
+worst finding.
+*/
+
+// DefaultImbalance flags an ELB whose busiest AZ has over 50% more healthy instances than its quietest.
+const DefaultImbalance = 0.5
+
+// ResilienceFinding is a weakness of an ELB.
+type ResilienceFinding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// Resilience grades an ELB, listing its healthy instances per AZ.
+type Resilience struct {
+	Id       string               `json:"id"`
+	Grade    string               `json:"grade"`
+	Zones    map[string]int       `json:"azs"`
+	Findings []*ResilienceFinding `json:"findings"`
+}
+
+func (r *Resilience) add(check string, severity Severity, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, &ResilienceFinding{check, severity, fmt.Sprintf(format, args...)})
+}
+
+// ResilienceReport holds the resilience of every ELB, ordered by id.
+type ResilienceReport struct {
+	LoadBalancers []*Resilience `json:"elbs"`
+}
+
+// AtLeast returns the ELBs with findings of severity min or worse.
+func (rr *ResilienceReport) AtLeast(min Severity) (elbs []*Resilience) {
+	for _, r := range rr.LoadBalancers {
+		for _, f := range r.Findings {
+			if f.Severity >= min {
+				elbs = append(elbs, r)
+				break
+			}
+		}
+	}
+
+	return elbs
+}
+
+func isHealthy(n NodeRef) bool {
+	state := n.Properties[StateProperty]
+	return !n.Unresolved && (state == "" || state == "running")
+}
+
+// AnalyzeResilience grades every ELB of g, flagging AZ imbalance above imbalance.
+func AnalyzeResilience(g *Graph, imbalance float64) (report *ResilienceReport) {
+	report = &ResilienceReport{LoadBalancers: []*Resilience{}}
+
+	for _, elb := range g.GetNodes(ByType(LoadBalancer)) {
+		r := &Resilience{Id: elb.Id, Zones: make(map[string]int), Findings: []*ResilienceFinding{}}
+
+		homes := make(map[string]bool)
+		subnets := make(map[string]bool)
+		instances := 0
+		for _, e := range g.Edges[elb.Id] {
+			switch e.Relationship {
+			case HomedIn:
+				if az := e.To.Properties[AzProperty]; az != "" {
+					homes[az] = true
+				}
+			case Proxies:
+				if !isHealthy(e.To) {
+					continue
+				}
+				instances++
+				az := e.To.Properties[AzProperty]
+				if az == "" {
+					az = "unknown"
+				}
+				r.Zones[az]++
+				subnets[e.To.Properties["subnet"]] = true
+			}
+		}
+
+		switch len(r.Zones) {
+		case 0:
+			r.add("single-az", High, "no healthy instances")
+		case 1:
+			for az := range r.Zones {
+				r.add("single-az", High, "all %d healthy instances in %v", instances, az)
+			}
+		}
+
+		if instances > 1 && len(subnets) == 1 {
+			for subnet := range subnets {
+				r.add("shared-subnet", Medium, "all %d healthy instances in %v", instances, subnet)
+			}
+		}
+
+		var empty []string
+		for az := range homes {
+			if r.Zones[az] == 0 {
+				empty = append(empty, az)
+			}
+		}
+		sort.Strings(empty)
+		if len(empty) > 0 && instances > 0 {
+			r.add("empty-az", Medium, "homed in %v without healthy instances", strings.Join(empty, ", "))
+		}
+
+		if len(r.Zones) > 1 {
+			least, most := instances, 0
+			for _, n := range r.Zones {
+				if n < least {
+					least = n
+				}
+				if n > most {
+					most = n
+				}
+			}
+			if float64(most) > float64(least)*(1+imbalance) {
+				r.add("az-imbalance", Low, "%v healthy instances per az", formatZones(r.Zones))
+			}
+		}
+
+		r.Grade = "A"
+		for _, f := range r.Findings {
+			if grade := string(rune('B' + f.Severity)); grade > r.Grade {
+				r.Grade = grade
+			}
+		}
+
+		report.LoadBalancers = append(report.LoadBalancers, r)
+	}
+
+	return report
+}
+
+// Only keeps the ELBs that are nodes of g, a selection of the graph the
+// report was analyzed on, so the selection doesn't change their grades.
+func (rr *ResilienceReport) Only(g *Graph) *ResilienceReport {
+	elbs := make([]*Resilience, 0, len(rr.LoadBalancers))
+	for _, r := range rr.LoadBalancers {
+		if _, err := g.GetNode(r.Id); err == nil {
+			elbs = append(elbs, r)
+		}
+	}
+	rr.LoadBalancers = elbs
+
+	return rr
+}
+
+func formatZones(zones map[string]int) string {
+	azs := make([]string, 0, len(zones))
+	for az, n := range zones {
+		azs = append(azs, fmt.Sprintf("%v=%d", az, n))
+	}
+	sort.Strings(azs)
+
+	return strings.Join(azs, " ")
+}
+
+// WriteTable writes a line per finding, or per ELB without findings.
+func (rr *ResilienceReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "elb\tgrade\tazs\tseverity\tcheck\tmessage")
+	for _, r := range rr.LoadBalancers {
+		if len(r.Findings) == 0 {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t\t\t\n", r.Id, r.Grade, formatZones(r.Zones))
+		}
+		for _, f := range r.Findings {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", r.Id, r.Grade, formatZones(r.Zones), f.Severity, f.Check, f.Message)
+		}
+	}
+
+	return tw.Flush()
+}
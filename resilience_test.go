@@ -0,0 +1,62 @@
+package main_test
+
+import (
+	"testing"
+)
+import . "."
+
+func Test_AnalyzeResilience_should_grade_each_elb(t *testing.T) {
+	region := snapshotFrom(t, `{
+		"Vpcs": [{"VPCID": "vpc-1"}],
+		"Subnets": [
+			{"SubnetID": "subnet-a", "VPCID": "vpc-1", "AvailabilityZone": "eu-west-1a"},
+			{"SubnetID": "subnet-b", "VPCID": "vpc-1", "AvailabilityZone": "eu-west-1b"}
+		],
+		"Instances": [
+			{"InstanceID": "i-1", "SubnetID": "subnet-a", "Placement": {"AvailabilityZone": "eu-west-1a"}, "State": {"Name": "running"}},
+			{"InstanceID": "i-2", "SubnetID": "subnet-a", "Placement": {"AvailabilityZone": "eu-west-1a"}, "State": {"Name": "running"}},
+			{"InstanceID": "i-3", "SubnetID": "subnet-b", "Placement": {"AvailabilityZone": "eu-west-1b"}, "State": {"Name": "stopped"}},
+			{"InstanceID": "i-4", "SubnetID": "subnet-b", "Placement": {"AvailabilityZone": "eu-west-1b"}, "State": {"Name": "running"}},
+			{"InstanceID": "i-5", "SubnetID": "subnet-b", "Placement": {"AvailabilityZone": "eu-west-1b"}, "State": {"Name": "running"}},
+			{"InstanceID": "i-6", "SubnetID": "subnet-b", "Placement": {"AvailabilityZone": "eu-west-1b"}, "State": {"Name": "running"}}
+		],
+		"LoadBalancers": [
+			{"LoadBalancerName": "web", "Subnets": ["subnet-a", "subnet-b"], "Instances": [{"InstanceID": "i-1"}, {"InstanceID": "i-2"}, {"InstanceID": "i-3"}]},
+			{"LoadBalancerName": "api", "Subnets": ["subnet-a", "subnet-b"], "Instances": [{"InstanceID": "i-1"}, {"InstanceID": "i-4"}, {"InstanceID": "i-5"}, {"InstanceID": "i-6"}]},
+			{"LoadBalancerName": "ok", "Subnets": ["subnet-a", "subnet-b"], "Instances": [{"InstanceID": "i-1"}, {"InstanceID": "i-4"}]}
+		]
+	}`).Region
+
+	graph := BuildGraph(&Config{Region: "eu-west-1"}, region)
+	report := AnalyzeResilience(graph, DefaultImbalance)
+
+	want := map[string]string{
+		"eu-west-1/elb/api": "B az-imbalance",
+		"eu-west-1/elb/ok":  "A",
+		"eu-west-1/elb/web": "D single-az shared-subnet empty-az",
+	}
+
+	for _, r := range report.LoadBalancers {
+		got := r.Grade
+		for _, f := range r.Findings {
+			got += " " + f.Check
+		}
+		if got != want[r.Id] {
+			t.Errorf("%v = %q, want %q", r.Id, got, want[r.Id])
+		}
+	}
+
+	if len(report.LoadBalancers) != len(want) || len(report.AtLeast(Medium)) != 1 {
+		t.Errorf("report = %+v, want 3 elbs, 1 with a medium finding or worse", report.LoadBalancers)
+	}
+	// a selection without the instances keeps the grades of the whole graph.
+	elbs, err := (&GraphSelection{Types: []string{"elb"}}).Apply(graph, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selected := AnalyzeResilience(graph, DefaultImbalance).Only(elbs)
+	if len(selected.LoadBalancers) != len(want) || selected.LoadBalancers[1].Grade != "A" {
+		t.Errorf("selected = %+v, want the 3 elbs graded as before", selected.LoadBalancers)
+	}
+}
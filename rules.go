@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+/* rules.
+
+Architecture rules checked against the graph, each written as queries in
+the query language:
+
+{
+  "rules": [
+    {
+      "name": "prod-elb-spans-two-azs",
+      "description": "Every prod ELB spans at least 2 AZs",
+      "severity": "high",
+      "match": "MATCH (e:elb) WHERE e.name STARTS WITH 'prod' RETURN e",
+      "expect": "MATCH (e:elb)-[:proxies]->(i:instance) RETURN e, i.az",
+      "min": 2
+    },
+    {
+      "name": "public-instance-behind-elb",
+      "description": "No instance in a public subnet without an ELB in front",
+      "match": "MATCH (:subnet {public: 'true'})-[:ip_allocated_to_instance]->(i:instance) RETURN i",
+      "expect": "MATCH (:elb)-[:proxies]->(i:instance) RETURN i"
+    },
+    {
+      "name": "owner-tag",
+      "description": "Every instance has an owner tag",
+      "match": "MATCH (i:instance) WHERE i.tag.owner = '' RETURN i"
+    }
+  ]
+}
+
+match returns the nodes a rule applies to as its first column. Without
+expect every one of them violates the rule. With expect, its rows are
+grouped by their first column, and each matched node must have at least
+min, default 1, and at most max distinct values of the other columns.
+severity is low, medium or high, low by default.
+
+Rules files are JSON rather than YAML so reading them needs nothing beyond
+the standard library, and with the logic in the query strings there's no
+structure YAML would make easier to write.
+*/
+
+// Rule is an architecture rule, see above.
+type Rule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Severity    Severity `json:"severity"`
+	Match       string   `json:"match"`
+	Expect      string   `json:"expect,omitempty"`
+	Min         *int     `json:"min,omitempty"`
+	Max         *int     `json:"max,omitempty"`
+
+	match, expect *GraphQuery
+}
+
+// RuleSet is a rules file.
+type RuleSet struct {
+	Rules []*Rule `json:"rules"`
+
+	// MaxSteps bounds each query the rules run, see GraphQuery.
+	MaxSteps int `json:"-"`
+}
+
+// returnsNode checks the first column of a query is a node.
+func returnsNode(gq *GraphQuery) bool {
+	if len(gq.Return) == 0 || gq.Return[0].Prop != "" {
+		return false
+	}
+
+	for _, n := range gq.Nodes {
+		if n.Var == gq.Return[0].Var {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compile parses the rule's queries.
+func (r *Rule) compile() (err error) {
+	if r.Name == "" {
+		return fmt.Errorf("rule %q: want a name", r.Match)
+	}
+
+	r.match, err = ParseQuery(r.Match)
+	if err != nil {
+		return fmt.Errorf("rule %v: match: %v", r.Name, err)
+	}
+
+	if !returnsNode(r.match) {
+		return fmt.Errorf("rule %v: match must return the node first", r.Name)
+	}
+
+	if r.Expect == "" {
+		if r.Min != nil || r.Max != nil {
+			return fmt.Errorf("rule %v: min and max need expect", r.Name)
+		}
+		return nil
+	}
+
+	r.expect, err = ParseQuery(r.Expect)
+	if err != nil {
+		return fmt.Errorf("rule %v: expect: %v", r.Name, err)
+	}
+
+	if !returnsNode(r.expect) {
+		return fmt.Errorf("rule %v: expect must return the node first", r.Name)
+	}
+
+	return nil
+}
+
+// ReadRules reads and compiles a rules file.
+func ReadRules(r io.Reader) (rs *RuleSet, err error) {
+	rs = &RuleSet{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range rs.Rules {
+		err = rule.compile()
+		if err != nil {
+			return nil, err
+		}
+
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %v: defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	return rs, nil
+}
+
+// LoadRules reads and compiles the rules file filename.
+func LoadRules(filename string) (rs *RuleSet, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rs, err = ReadRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	return rs, nil
+}
+
+// Violation is a node breaking a rule.
+type Violation struct {
+	Id      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// RuleResult is the outcome of a rule, passed when it has no violations.
+type RuleResult struct {
+	Rule        string       `json:"rule"`
+	Description string       `json:"description,omitempty"`
+	Severity    Severity     `json:"severity"`
+	Checked     int          `json:"checked"`
+	Violations  []*Violation `json:"violations"`
+
+	// Truncated is set when a query stopped at MaxSteps, Violations are then unreliable.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// RulesReport holds a result per rule, in the order of the rules file.
+type RulesReport struct {
+	Results []*RuleResult `json:"results"`
+}
+
+// Violations counts the violations of severity min or worse.
+func (rr *RulesReport) Violations(min Severity) (n int) {
+	for _, r := range rr.Results {
+		if r.Severity >= min {
+			n += len(r.Violations)
+		}
+	}
+
+	return n
+}
+
+// Evaluate checks every rule against g.
+func (rs *RuleSet) Evaluate(g *Graph) (report *RulesReport) {
+	report = &RulesReport{Results: make([]*RuleResult, 0, len(rs.Rules))}
+	for _, rule := range rs.Rules {
+		report.Results = append(report.Results, rule.Evaluate(g, rs.MaxSteps))
+	}
+
+	return report
+}
+
+// Evaluate checks the rule against g, running its queries for at most maxSteps, 0 for no bound.
+func (r *Rule) Evaluate(g *Graph, maxSteps int) (result *RuleResult) {
+	result = &RuleResult{Rule: r.Name, Description: r.Description, Severity: r.Severity, Violations: []*Violation{}}
+
+	// rules are shared by concurrent requests, bound a copy of the query.
+	run := func(gq *GraphQuery) *QueryResult {
+		bounded := *gq
+		bounded.MaxSteps = maxSteps
+		qr := bounded.Run(g)
+		result.Truncated = result.Truncated || qr.Truncated
+		return qr
+	}
+
+	subjects := make(map[string]bool)
+	for _, row := range run(r.match).Rows {
+		subjects[row[0]] = true
+	}
+	result.Checked = len(subjects)
+
+	var counts map[string]map[string]bool
+	if r.expect != nil {
+		counts = make(map[string]map[string]bool)
+		for _, row := range run(r.expect).Rows {
+			if counts[row[0]] == nil {
+				counts[row[0]] = make(map[string]bool)
+			}
+			counts[row[0]][strings.Join(row[1:], "\x00")] = true
+		}
+	}
+
+	min := 1
+	if r.Min != nil {
+		min = *r.Min
+	}
+
+	for id := range subjects {
+		switch {
+		case r.expect == nil:
+			result.Violations = append(result.Violations, &Violation{id, "matched"})
+		case len(counts[id]) < min:
+			result.Violations = append(result.Violations, &Violation{id, fmt.Sprintf("has %d, want at least %d", len(counts[id]), min)})
+		case r.Max != nil && len(counts[id]) > *r.Max:
+			result.Violations = append(result.Violations, &Violation{id, fmt.Sprintf("has %d, want at most %d", len(counts[id]), *r.Max)})
+		}
+	}
+
+	sort.Slice(result.Violations, func(i, j int) bool { return result.Violations[i].Id < result.Violations[j].Id })
+
+	return result
+}
+
+// WriteText writes a line per rule followed by its violations.
+func (rr *RulesReport) WriteText(w io.Writer) (err error) {
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	for _, r := range rr.Results {
+		status := "ok"
+		if len(r.Violations) > 0 {
+			status = fmt.Sprintf("%d violations", len(r.Violations))
+		}
+		if r.Truncated {
+			status += ", truncated"
+		}
+
+		description := ""
+		if r.Description != "" {
+			description = " (" + r.Description + ")"
+		}
+
+		printf("%v [%v]%v: %d checked, %v\n", r.Rule, r.Severity, description, r.Checked, status)
+		for _, v := range r.Violations {
+			printf("  %v: %v\n", v.Id, v.Message)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+)
+import . "."
+
+func Test_RuleSet_Evaluate_should_report_violating_nodes_per_rule(t *testing.T) {
+	rules, err := ReadRules(strings.NewReader(`{"rules": [
+		{"name": "prod-elb-spans-two-azs", "severity": "high", "min": 2,
+		 "match": "MATCH (e:elb) WHERE e.name STARTS WITH 'prod' RETURN e",
+		 "expect": "MATCH (e:elb)-[:proxies]->(i:instance) RETURN e, i.az"},
+		{"name": "public-instance-behind-elb",
+		 "match": "MATCH (:subnet {public: 'true'})-[:ip_allocated_to_instance]->(i:instance) RETURN i",
+		 "expect": "MATCH (:elb)-[:proxies]->(i:instance) RETURN i"},
+		{"name": "owner-tag", "severity": "medium",
+		 "match": "MATCH (i:instance) WHERE i.tag.owner = '' RETURN i"}
+	]}`))
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	config := &Config{Region: "eu-west-1"}
+	region := snapshotFrom(t, `{
+		"Vpcs": [{"VPCID": "vpc-1"}],
+		"Subnets": [
+			{"SubnetID": "subnet-a", "VPCID": "vpc-1", "AvailabilityZone": "eu-west-1a"},
+			{"SubnetID": "subnet-b", "VPCID": "vpc-1", "AvailabilityZone": "eu-west-1b"}
+		],
+		"Routes": [{"RouteTableID": "rtb-1", "VPCID": "vpc-1", "Associations": [{"SubnetID": "subnet-a"}], "Routes": [{"DestinationCIDRBlock": "0.0.0.0/0", "GatewayID": "igw-1"}]}],
+		"Instances": [
+			{"InstanceID": "i-1", "SubnetID": "subnet-a", "VPCID": "vpc-1", "Placement": {"AvailabilityZone": "eu-west-1a"}, "Tags": [{"Key": "owner", "Value": "ops"}]},
+			{"InstanceID": "i-2", "SubnetID": "subnet-a", "VPCID": "vpc-1", "Placement": {"AvailabilityZone": "eu-west-1a"}},
+			{"InstanceID": "i-3", "SubnetID": "subnet-b", "VPCID": "vpc-1", "Placement": {"AvailabilityZone": "eu-west-1b"}, "Tags": [{"Key": "owner", "Value": "ops"}]}
+		],
+		"LoadBalancers": [
+			{"LoadBalancerName": "prod-web", "VPCID": "vpc-1", "Subnets": ["subnet-a"], "Instances": [{"InstanceID": "i-1"}]},
+			{"LoadBalancerName": "prod-api", "VPCID": "vpc-1", "Subnets": ["subnet-a", "subnet-b"], "Instances": [{"InstanceID": "i-1"}, {"InstanceID": "i-3"}]}
+		]
+	}`).Region
+
+	report := rules.Evaluate(BuildGraph(config, region))
+
+	want := map[string]string{
+		"prod-elb-spans-two-azs":     "eu-west-1/elb/prod-web: has 1, want at least 2",
+		"public-instance-behind-elb": "eu-west-1/instance/i-2: has 0, want at least 1",
+		"owner-tag":                  "eu-west-1/instance/i-2: matched",
+	}
+
+	for _, r := range report.Results {
+		var got []string
+		for _, v := range r.Violations {
+			got = append(got, v.Id+": "+v.Message)
+		}
+		if strings.Join(got, "; ") != want[r.Rule] {
+			t.Errorf("%v violations = %v, want %v", r.Rule, got, want[r.Rule])
+		}
+	}
+
+	if report.Violations(Medium) != 2 {
+		t.Errorf("report.Violations(Medium) = %v, want 2", report.Violations(Medium))
+	}
+
+	rules.MaxSteps = 1
+	for _, r := range rules.Evaluate(BuildGraph(config, region)).Results {
+		if !r.Truncated {
+			t.Errorf("%v truncated = false, want true with MaxSteps 1", r.Rule)
+		}
+	}
+}
+
+func Test_ReadRules_should_reject_rules_not_returning_a_node(t *testing.T) {
+	_, err := ReadRules(strings.NewReader(`{"rules": [{"name": "azs", "match": "MATCH (i:instance) RETURN i.az"}]}`))
+	if err == nil || !strings.Contains(err.Error(), "must return the node first") {
+		t.Fatalf("err = %v, want the match to return a node", err)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// GraphView is a built graph with the config and metadata it was built from.
+// Views are never modified once built, a refresh builds a new one.
+type GraphView struct {
+	Graph    *Graph
+	Config   *Config
+	Metadata *SnapshotMetadata
+	Report   *BuildReport
+
+	// Region is the snapshot the graph was built from, nil for a graph loaded from graph json.
+	Region *AwsRegion
+}
+
+// Select returns a copy of the view with the subgraph selected by s,
+// containers forces the containers of the selected nodes to be kept.
+func (view *GraphView) Select(s *GraphSelection, containers bool) (selected *GraphView, err error) {
+	graph, err := s.Apply(view.Graph, containers)
+	if err != nil {
+		return nil, err
+	}
+
+	selected = new(GraphView)
+	*selected = *view
+	selected.Graph = graph
+
+	return selected, nil
+}
+
+// Reachability analyzes the view's snapshot, reporting on the instances and ELBs in the graph.
+func (view *GraphView) Reachability() (report *ReachabilityReport, err error) {
+	if view.Region == nil {
+		return nil, NoRegionData
+	}
+
+	report = AnalyzeReachability(view.Config, view.Region)
+	exposures := report.Exposures[:0]
+	for _, e := range report.Exposures {
+		if _, err := view.Graph.GetNode(e.Id); err == nil {
+			exposures = append(exposures, e)
+		}
+	}
+	report.Exposures = exposures
+
+	return report, nil
+}
+
+// GraphHandler serves the current view, which can be replaced with Swap while
+// requests are in flight. Each request renders the view it started with.
+type GraphHandler struct {
+	Store *SnapshotStore
+	Rules *RuleSet
+
+	current atomic.Value
+}
+
+// NewGraphHandler
+func NewGraphHandler(view *GraphView, store *SnapshotStore) (gs *GraphHandler) {
+	gs = &GraphHandler{Store: store}
+	gs.Swap(view)
+
+	return gs
+}
+
+// Current returns the view being served.
+func (gs *GraphHandler) Current() *GraphView {
+	return gs.current.Load().(*GraphView)
+}
+
+// Swap atomically replaces the view being served.
+func (gs *GraphHandler) Swap(view *GraphView) {
+	gs.current.Store(view)
+}
+
+// Refresh rebuilds the view with load every interval, keeping the current view when load fails.
+func (gs *GraphHandler) Refresh(interval time.Duration, load func() (*GraphView, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		view, err := load()
+		if err != nil {
+			log.Printf("refresh failed, still serving %v: %v", gs.Current().Metadata.CollectedAt, err)
+			continue
+		}
+
+		gs.Swap(view)
+	}
+}
+
+// selectView returns the view a request renders, which is the current view unless
+// the request selects another snapshot from the store with ?snapshot=name or ?at=time.
+func (gs *GraphHandler) selectView(req *http.Request) (view *GraphView, err error) {
+	view = gs.Current()
+
+	q := req.URL.Query()
+	if gs.Store == nil || (q.Get("snapshot") == "" && q.Get("at") == "") {
+		return view, nil
+	}
+
+	snapshot, err := selectSnapshot(gs.Store, q.Get("snapshot"), q.Get("at"))
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotView(view.Config, snapshot), nil
+}
+
+// baseView returns the view the ?changes= parameter compares view against,
+// the named store snapshot or, for previous, the one collected before view.
+func (gs *GraphHandler) baseView(req *http.Request, view *GraphView) (base *GraphView, err error) {
+	if gs.Store == nil {
+		return nil, SnapshotNotFound
+	}
+
+	name := req.URL.Query().Get("changes")
+	if name == "" || name == "previous" {
+		stored, err := gs.Store.Before(view.Metadata.CollectedAt)
+		if err != nil {
+			return nil, err
+		}
+		name = stored.Name
+	}
+
+	snapshot, err := gs.Store.Load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotView(view.Config, snapshot), nil
+}
+
+// selectGraph narrows view to the subgraph selected by the request's
+// parameters, or by the -seed and -select flags when it has none.
+func (gs *GraphHandler) selectGraph(req *http.Request, view *GraphView, containers bool) (*GraphView, error) {
+	s, err := selectionFromQuery(req.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	if s.IsEmpty() {
+		s = &view.Config.Selection
+	}
+
+	return view.Select(s, containers)
+}
+
+func (gs *GraphHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/" {
+		fmt.Fprintf(w, IndexPage)
+		return
+	}
+
+	if req.URL.Path == "/region.json" {
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		view, err = gs.selectGraph(req, view, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config := view.Config
+		if order := req.URL.Query().Get("order"); order != "" {
+			c := *config
+			c.Order = order
+			config = &c
+		}
+
+		root, err := generateDendogram(config, view.Graph)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+
+		err = enc.Encode(root)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/snapshot.json" {
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(view.Metadata)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/snapshots.json" {
+		snapshots := make([]*StoredSnapshot, 0)
+		if gs.Store != nil {
+			var err error
+			snapshots, err = gs.Store.List()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		err := json.NewEncoder(w).Encode(snapshots)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/graph.json" {
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		view, err = gs.selectGraph(req, view, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = WriteGraph(w, view)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/changes.json" {
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		base, err := gs.baseView(req, view)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(DiffGraphs(base.Graph, view.Graph))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/report.json" {
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(view.Report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/exposure.json" {
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		view, err = gs.selectGraph(req, view, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		report, err := view.Reachability()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/resilience.json" {
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// ELBs are graded on the whole graph, a selection may leave out their instances.
+		selected, err := gs.selectGraph(req, view, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		imbalance := DefaultImbalance
+		if v := req.URL.Query().Get("imbalance"); v != "" {
+			imbalance, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				http.Error(w, "imbalance: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		err = json.NewEncoder(w).Encode(AnalyzeResilience(view.Graph, imbalance).Only(selected.Graph))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/rules.json" {
+		if gs.Rules == nil {
+			http.Error(w, "no -rules file given", http.StatusNotFound)
+			return
+		}
+
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		view, err = gs.selectGraph(req, view, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(gs.Rules.Evaluate(view.Graph))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if req.URL.Path == "/query" {
+		view, err := gs.selectView(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		view, err = gs.selectGraph(req, view, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		q, err := ParseQuery(req.URL.Query().Get("q"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.MaxSteps = DefaultMaxSteps
+
+		format := req.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+
+		err = q.Run(view.Graph).WriteResult(w, format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+
+		return
+	}
+
+	http.NotFound(w, req)
+}
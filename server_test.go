@@ -0,0 +1,42 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+import . "."
+
+func Test_GraphHandler_should_serve_while_the_view_is_swapped(t *testing.T) {
+	config := &Config{Region: "eu-west-1"}
+	view := func(vpcs int) *GraphView {
+		return &GraphView{Graph: BuildGraph(config, syntheticRegion(vpcs, 3, 2)), Config: config, Metadata: &SnapshotMetadata{}}
+	}
+
+	handler := NewGraphHandler(view(1), nil)
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				handler.Swap(view(i%2 + 1))
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", "/region.json", nil))
+				if w.Code != http.StatusOK {
+					t.Errorf("w.Code = %v, want 200", w.Code)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
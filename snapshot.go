@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// SnapshotSchemaVersion is bumped whenever the envelope or region layout changes incompatibly.
+const SnapshotSchemaVersion = 1
+
+var UnsupportedSnapshotVersion = errors.New("Snapshot schema version is newer than this awsmap supports!")
+
+// Snapshot is the envelope written to disk around a collected region.
+type Snapshot struct {
+	SchemaVersion int              `json:"schema_version"`
+	Metadata      SnapshotMetadata `json:"metadata"`
+	Region        *AwsRegion       `json:"region"`
+
+	// Provenance maps resource ids to the sources they were collected from, set on merged snapshots.
+	// ELB names are prefixed with account/ when the source's account is known, see resourceKey.
+	Provenance map[string][]string `json:"provenance,omitempty"`
+
+	// Accounts lists the account of every resource of a merge of several
+	// accounts, by region field in the order of the field's resources.
+	Accounts map[string][]string `json:"accounts,omitempty"`
+}
+
+// account returns the account the index'th resource of the named region field was collected in.
+func (s *Snapshot) account(field string, index int) string {
+	if accounts, ok := s.Accounts[field]; ok && index < len(accounts) {
+		return accounts[index]
+	}
+
+	return s.Metadata.Account
+}
+
+// owners maps each resource of a merge of several accounts to its account.
+func (s *Snapshot) owners() (owners map[interface{}]string) {
+	if len(s.Accounts) == 0 {
+		return nil
+	}
+
+	owners = make(map[interface{}]string)
+	v := reflect.ValueOf(s.Region).Elem()
+	for f := 0; f < v.NumField(); f++ {
+		for r := 0; r < v.Field(f).Len(); r++ {
+			owners[v.Field(f).Index(r).Interface()] = s.account(v.Type().Field(f).Name, r)
+		}
+	}
+
+	return owners
+}
+
+// SnapshotMetadata records when, where from and how a snapshot was collected.
+type SnapshotMetadata struct {
+	CollectedAt   time.Time `json:"collected_at"`
+	Account       string    `json:"account,omitempty"`
+	Region        string    `json:"region"`
+	Source        string    `json:"source"`
+	AwsmapVersion string    `json:"awsmap_version"`
+	Collectors    []string  `json:"collectors,omitempty"`
+	Redacted      bool      `json:"redacted,omitempty"`
+
+	// Parts holds the metadata of the snapshots a merged snapshot was built from.
+	Parts []SnapshotMetadata `json:"parts,omitempty"`
+}
+
+// NewSnapshot wraps region with metadata describing the current run.
+func NewSnapshot(config *Config, source string, collectors []string, region *AwsRegion) (s *Snapshot) {
+	return &Snapshot{
+		SchemaVersion: SnapshotSchemaVersion,
+		Metadata: SnapshotMetadata{
+			CollectedAt:   time.Now().UTC(),
+			Account:       regionAccount(region),
+			Region:        config.Region,
+			Source:        source,
+			AwsmapVersion: Version,
+			Collectors:    collectors,
+		},
+		Region: region,
+	}
+}
+
+// ReadSnapshot decodes a snapshot envelope, or a bare AwsRegion as written
+// before the envelope existed. Bare regions are returned with schema version 0
+// and empty metadata. Resources are decoded one at a time so the whole document
+// is never buffered.
+func ReadSnapshot(r io.Reader) (s *Snapshot, err error) {
+	dec := json.NewDecoder(r)
+	s = &Snapshot{Region: &AwsRegion{}}
+
+	err = expectDelim(dec, '{')
+	if err != nil {
+		return nil, err
+	}
+
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "schema_version":
+			err = dec.Decode(&s.SchemaVersion)
+			if err == nil && s.SchemaVersion > SnapshotSchemaVersion {
+				err = UnsupportedSnapshotVersion
+			}
+		case "metadata":
+			err = dec.Decode(&s.Metadata)
+		case "region":
+			err = decodeRegion(dec, s.Region)
+		case "provenance":
+			err = dec.Decode(&s.Provenance)
+		case "accounts":
+			err = dec.Decode(&s.Accounts)
+		default: // a bare AwsRegion
+			err = decodeRegionField(dec, s.Region, key)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if tok != delim {
+		return fmt.Errorf("snapshot: found %v, want %v", tok, delim)
+	}
+
+	return nil
+}
+
+func objectKey(dec *json.Decoder) (key string, err error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("snapshot: found %v, want an object key", tok)
+	}
+
+	return key, nil
+}
+
+func decodeRegion(dec *json.Decoder, region *AwsRegion) error {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+
+	if tok != json.Delim('{') {
+		return fmt.Errorf("snapshot: found %v, want a region object", tok)
+	}
+
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return err
+		}
+
+		err = decodeRegionField(dec, region, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+// decodeRegionField appends each element of the named AwsRegion slice as it is read.
+func decodeRegionField(dec *json.Decoder, region *AwsRegion, name string) error {
+	field := reflect.ValueOf(region).Elem().FieldByName(name)
+	if !field.IsValid() || field.Kind() != reflect.Slice {
+		var skip json.RawMessage
+		return dec.Decode(&skip)
+	}
+
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+
+	if tok != json.Delim('[') {
+		return fmt.Errorf("snapshot: found %v, want a list of %v", tok, name)
+	}
+
+	for dec.More() {
+		elem := reflect.New(field.Type().Elem())
+		err = dec.Decode(elem.Interface())
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.Append(field, elem.Elem()))
+	}
+
+	return expectDelim(dec, ']')
+}
+
+// WriteSnapshot encodes s one resource at a time, so no more than a single
+// resource is marshalled in memory at once.
+func WriteSnapshot(w io.Writer, s *Snapshot) (err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	fmt.Fprintf(bw, `{"schema_version":%d,"metadata":`, s.SchemaVersion)
+	err = enc.Encode(s.Metadata)
+	if err != nil {
+		return err
+	}
+
+	bw.WriteString(`,"region":`)
+	err = encodeRegion(bw, enc, s.Region)
+	if err != nil {
+		return err
+	}
+
+	if len(s.Provenance) > 0 {
+		bw.WriteString(`,"provenance":`)
+		err = enc.Encode(s.Provenance)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.Accounts) > 0 {
+		bw.WriteString(`,"accounts":`)
+		err = enc.Encode(s.Accounts)
+		if err != nil {
+			return err
+		}
+	}
+
+	bw.WriteString("}\n")
+
+	return bw.Flush()
+}
+
+func encodeRegion(w *bufio.Writer, enc *json.Encoder, region *AwsRegion) (err error) {
+	if region == nil {
+		_, err = w.WriteString("null")
+		return err
+	}
+
+	v := reflect.ValueOf(region).Elem()
+	w.WriteByte('{')
+	for i := 0; i < v.NumField(); i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, "%q:[", v.Type().Field(i).Name)
+
+		field := v.Field(i)
+		for j := 0; j < field.Len(); j++ {
+			if j > 0 {
+				w.WriteByte(',')
+			}
+
+			err = enc.Encode(field.Index(j).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		w.WriteByte(']')
+	}
+	_, err = w.WriteString("}")
+
+	return err
+}
+
+// Compression is chosen by file extension, e.g. region.json.gz or region.json.zst.
+const (
+	GzipExt = ".gz"
+	ZstdExt = ".zst"
+)
+
+// closers closes a stack of readers or writers, innermost first.
+type closers []io.Closer
+
+func (cs closers) Close() (err error) {
+	for _, c := range cs {
+		cerr := c.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+type snapshotReader struct {
+	io.Reader
+	closers
+}
+
+type snapshotWriter struct {
+	io.Writer
+	closers
+}
+
+func openSnapshot(filename string) (rc io.ReadCloser, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(filename) {
+	case GzipExt:
+		gz, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &snapshotReader{gz, closers{gz, f}}, nil
+	case ZstdExt, ".zstd":
+		zr, err := zstd.NewReader(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &snapshotReader{zr, closers{zr.IOReadCloser(), f}}, nil
+	}
+
+	return &snapshotReader{bufio.NewReader(f), closers{f}}, nil
+}
+
+// createSnapshot creates filename for writing, flag is os.O_TRUNC to replace
+// an existing file or os.O_EXCL to fail with os.ErrExist.
+func createSnapshot(filename string, flag int) (wc io.WriteCloser, err error) {
+	f, err := os.OpenFile(filename, flag|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(filename) {
+	case GzipExt:
+		gz := gzip.NewWriter(f)
+		return &snapshotWriter{gz, closers{gz, f}}, nil
+	case ZstdExt, ".zstd":
+		zw, err := zstd.NewWriter(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &snapshotWriter{zw, closers{zw, f}}, nil
+	}
+
+	return &snapshotWriter{f, closers{f}}, nil
+}
+
+func loadSnapshot(filename string) (s *Snapshot, err error) {
+	r, err := openSnapshot(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ReadSnapshot(r)
+}
+
+func saveSnapshot(filename string, s *Snapshot) (err error) {
+	return writeSnapshotFile(filename, os.O_TRUNC, s)
+}
+
+func writeSnapshotFile(filename string, flag int, s *Snapshot) (err error) {
+	w, err := createSnapshot(filename, flag)
+	if err != nil {
+		return err
+	}
+
+	err = WriteSnapshot(w, s)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
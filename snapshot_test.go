@@ -0,0 +1,196 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
+import . "."
+
+func Test_ReadSnapshot_should_load_a_bare_AwsRegion(t *testing.T) {
+	s, err := ReadSnapshot(strings.NewReader(`{"Vpcs": [{"VPCID": "vpc-1"}]}`))
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if s.SchemaVersion != 0 {
+		t.Fatalf("s.SchemaVersion = %v, want 0", s.SchemaVersion)
+	}
+
+	if len(s.Region.Vpcs) != 1 {
+		t.Fatalf("len(s.Region.Vpcs) = %v, want 1", len(s.Region.Vpcs))
+	}
+}
+
+func Test_ReadSnapshot_should_round_trip_an_envelope(t *testing.T) {
+	config := &Config{Region: "eu-west-1"}
+	var buf bytes.Buffer
+
+	err := WriteSnapshot(&buf, NewSnapshot(config, "aws", []string{"vpcs"}, &AwsRegion{}))
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	s, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if s.SchemaVersion != SnapshotSchemaVersion || s.Metadata.Region != "eu-west-1" || s.Metadata.Source != "aws" {
+		t.Fatalf("s = %+v, want the written envelope", s)
+	}
+}
+
+func Test_ReadSnapshot_should_reject_newer_schema_versions(t *testing.T) {
+	_, err := ReadSnapshot(strings.NewReader(`{"schema_version": 999, "region": {}}`))
+	if err != UnsupportedSnapshotVersion {
+		t.Fatalf("err = %v, want UnsupportedSnapshotVersion", err)
+	}
+}
+
+func Test_SnapshotStore_should_round_trip_compressed_snapshots(t *testing.T) {
+	for _, ext := range []string{"", GzipExt, ZstdExt} {
+		store := storeWith(t, RetentionPolicy{})
+		store.Compression = ext
+
+		region := &AwsRegion{Vpcs: []*ec2.VPC{{VPCID: aws.String("vpc-1")}}}
+		name, err := store.Save(&Snapshot{SchemaVersion: SnapshotSchemaVersion, Region: region})
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+
+		if !strings.HasSuffix(name, ".json"+ext) {
+			t.Fatalf("name = %v, want suffix .json%v", name, ext)
+		}
+
+		s, err := store.Load(name)
+		if err != nil {
+			t.Fatalf("err = %v, want nil", err)
+		}
+
+		if len(s.Region.Vpcs) != 1 || *s.Region.Vpcs[0].VPCID != "vpc-1" {
+			t.Fatalf("s.Region.Vpcs = %v, want vpc-1", s.Region.Vpcs)
+		}
+
+		os.RemoveAll(store.Dir)
+	}
+}
+
+// syntheticRegion builds an estate of vpcs*subnets*instances instances, one ELB per subnet.
+func syntheticRegion(vpcs, subnets, instances int) *AwsRegion {
+	region := &AwsRegion{}
+	azs := []string{"eu-west-1a", "eu-west-1b", "eu-west-1c"}
+
+	for v := 0; v < vpcs; v++ {
+		vpcId := fmt.Sprintf("vpc-%d", v)
+		region.Vpcs = append(region.Vpcs, &ec2.VPC{VPCID: aws.String(vpcId), CIDRBlock: aws.String("10.0.0.0/16")})
+
+		for s := 0; s < subnets; s++ {
+			subnetId := fmt.Sprintf("subnet-%d-%d", v, s)
+			region.Subnets = append(region.Subnets, &ec2.Subnet{
+				SubnetID:         aws.String(subnetId),
+				VPCID:            aws.String(vpcId),
+				AvailabilityZone: aws.String(azs[s%len(azs)]),
+				CIDRBlock:        aws.String(fmt.Sprintf("10.0.%d.0/24", s)),
+				Tags:             []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(subnetId)}},
+			})
+
+			lb := &elb.LoadBalancerDescription{
+				LoadBalancerName: aws.String(fmt.Sprintf("elb-%d-%d", v, s)),
+				Subnets:          []*string{aws.String(subnetId)},
+			}
+
+			for i := 0; i < instances; i++ {
+				instanceId := fmt.Sprintf("i-%d-%d-%d", v, s, i)
+				region.Instances = append(region.Instances, &ec2.Instance{
+					InstanceID:       aws.String(instanceId),
+					SubnetID:         aws.String(subnetId),
+					VPCID:            aws.String(vpcId),
+					PrivateIPAddress: aws.String(fmt.Sprintf("10.0.%d.%d", s, i)),
+					Placement:        &ec2.Placement{AvailabilityZone: aws.String(azs[s%len(azs)])},
+					Tags:             []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(instanceId)}},
+				})
+				lb.Instances = append(lb.Instances, &elb.Instance{InstanceID: aws.String(instanceId)})
+			}
+
+			region.LoadBalancers = append(region.LoadBalancers, lb)
+		}
+	}
+
+	return region
+}
+
+// Benchmark_serve_startup measures loading a snapshot and building its graph,
+// which is what -serve does before listening. peak-heap-MB is the most heap in
+// use sampled while starting up, alloc-MB/op the total allocated along the way.
+func Benchmark_serve_startup(b *testing.B) {
+	for _, ext := range []string{"", GzipExt, ZstdExt} {
+		b.Run("json"+ext, func(b *testing.B) {
+			dir, _ := ioutil.TempDir("", "awsmap")
+			defer os.RemoveAll(dir)
+
+			store, _ := NewSnapshotStore(dir, RetentionPolicy{})
+			store.Compression = ext
+			name, err := store.Save(&Snapshot{SchemaVersion: SnapshotSchemaVersion, Region: syntheticRegion(10, 30, 50)})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			config := &Config{Region: "eu-west-1"}
+			var before, after runtime.MemStats
+			var graph *Graph
+
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			done := make(chan bool)
+			peak := make(chan uint64)
+			go func() {
+				var max uint64
+				var m runtime.MemStats
+				ticker := time.NewTicker(time.Millisecond)
+				defer ticker.Stop()
+				for {
+					runtime.ReadMemStats(&m)
+					if m.HeapInuse > max {
+						max = m.HeapInuse
+					}
+
+					select {
+					case <-done:
+						peak <- max
+						return
+					case <-ticker.C:
+					}
+				}
+			}()
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				s, err := store.Load(name)
+				if err != nil {
+					b.Fatal(err)
+				}
+				graph = BuildGraph(config, s.Region)
+			}
+
+			b.StopTimer()
+			close(done)
+			runtime.ReadMemStats(&after)
+
+			b.ReportMetric(float64(<-peak)/(1<<20), "peak-heap-MB")
+			b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/float64(b.N)/(1<<20), "alloc-MB/op")
+			runtime.KeepAlive(graph)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const snapshotTimeFormat = "20060102T150405Z"
+const snapshotPrefix = "snapshot-"
+const snapshotSuffix = ".json"
+
+var SnapshotNotFound = errors.New("Snapshot not found!")
+var UnknownCompression = errors.New("Compression must be gz or zst!")
+
+// RetentionPolicy bounds how many snapshots a store keeps. Zero values are unlimited.
+type RetentionPolicy struct {
+	MaxCount int
+	MaxAge   time.Duration
+}
+
+// SnapshotStore keeps timestamped snapshots in a directory. Compression is the
+// extension added to new snapshots, GzipExt, ZstdExt or empty for plain JSON.
+type SnapshotStore struct {
+	Dir         string
+	Retention   RetentionPolicy
+	Compression string
+}
+
+// StoredSnapshot identifies a snapshot in the store by name and collection time.
+type StoredSnapshot struct {
+	Name        string    `json:"name"`
+	CollectedAt time.Time `json:"collected_at"`
+
+	seq int // orders snapshots collected within the same second
+}
+
+// NewSnapshotStore
+func NewSnapshotStore(dir string, retention RetentionPolicy) (s *SnapshotStore, err error) {
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotStore{Dir: dir, Retention: retention}, nil
+}
+
+// Save writes snap into the store, named by its collection time and a counter when
+// another snapshot has the same second, then applies the retention policy.
+func (s *SnapshotStore) Save(snap *Snapshot) (name string, err error) {
+	at := snap.Metadata.CollectedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	stamp := snapshotPrefix + at.UTC().Format(snapshotTimeFormat)
+	for seq := 1; ; seq++ {
+		name = stamp + snapshotSuffix + s.Compression
+		if seq > 1 {
+			name = stamp + "-" + strconv.Itoa(seq) + snapshotSuffix + s.Compression
+		}
+
+		err = writeSnapshotFile(filepath.Join(s.Dir, name), os.O_EXCL, snap)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.Prune(time.Now())
+
+	return name, err
+}
+
+// List returns the stored snapshots, oldest first.
+func (s *SnapshotStore) List() (snapshots []*StoredSnapshot, err error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasPrefix(name, snapshotPrefix) {
+			continue
+		}
+
+		ts := strings.TrimPrefix(name, snapshotPrefix)
+		ts = ts[:strings.Index(ts+".", ".")]
+		seq := 1
+		if i := strings.Index(ts, "-"); i >= 0 {
+			n, err := strconv.Atoi(ts[i+1:])
+			if err != nil {
+				continue
+			}
+			seq, ts = n, ts[:i]
+		}
+
+		at, err := time.Parse(snapshotTimeFormat, ts)
+		if err != nil {
+			continue
+		}
+
+		snapshots = append(snapshots, &StoredSnapshot{Name: name, CollectedAt: at, seq: seq})
+	}
+
+	sort.Sort(byCollectedAt(snapshots))
+
+	return snapshots, nil
+}
+
+// Load reads the named snapshot.
+func (s *SnapshotStore) Load(name string) (snap *Snapshot, err error) {
+	if name != filepath.Base(name) {
+		return nil, SnapshotNotFound
+	}
+
+	snap, err = loadSnapshot(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, SnapshotNotFound
+	}
+
+	return snap, err
+}
+
+// Latest returns the most recent snapshot.
+func (s *SnapshotStore) Latest() (stored *StoredSnapshot, err error) {
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snapshots) == 0 {
+		return nil, SnapshotNotFound
+	}
+
+	return snapshots[len(snapshots)-1], nil
+}
+
+// Closest returns the last snapshot collected at or before t, which shows the
+// network as it was at that moment. When t predates every snapshot there is none.
+func (s *SnapshotStore) Closest(t time.Time) (stored *StoredSnapshot, err error) {
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snap := range snapshots {
+		if snap.CollectedAt.After(t) {
+			break
+		}
+		stored = snap
+	}
+
+	if stored == nil {
+		return nil, SnapshotNotFound
+	}
+
+	return stored, nil
+}
+
+// Before returns the last snapshot collected before t, to compare the snapshot collected at t against.
+func (s *SnapshotStore) Before(t time.Time) (stored *StoredSnapshot, err error) {
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// snapshot names only keep whole seconds.
+	t = t.Truncate(time.Second)
+	for _, snap := range snapshots {
+		if !snap.CollectedAt.Before(t) {
+			break
+		}
+		stored = snap
+	}
+
+	if stored == nil {
+		return nil, SnapshotNotFound
+	}
+
+	return stored, nil
+}
+
+// Prune deletes the snapshots outside the retention policy. The newest snapshot is always kept.
+func (s *SnapshotStore) Prune(now time.Time) (removed []string, err error) {
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, snap := range snapshots {
+		newest := len(snapshots) - i
+		expired := s.Retention.MaxAge > 0 && now.Sub(snap.CollectedAt) > s.Retention.MaxAge
+		overflow := s.Retention.MaxCount > 0 && newest > s.Retention.MaxCount
+		if newest == 1 || !(expired || overflow) {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(s.Dir, snap.Name))
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, snap.Name)
+	}
+
+	return removed, nil
+}
+
+type byCollectedAt []*StoredSnapshot
+
+func (b byCollectedAt) Len() int { return len(b) }
+func (b byCollectedAt) Less(i, j int) bool {
+	if b[i].CollectedAt.Equal(b[j].CollectedAt) {
+		return b[i].seq < b[j].seq
+	}
+	return b[i].CollectedAt.Before(b[j].CollectedAt)
+}
+func (b byCollectedAt) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
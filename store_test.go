@@ -0,0 +1,108 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+import . "."
+
+func storeWith(t *testing.T, retention RetentionPolicy, times ...time.Time) *SnapshotStore {
+	dir, err := ioutil.TempDir("", "awsmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewSnapshotStore(dir, RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, at := range times {
+		snap := &Snapshot{Metadata: SnapshotMetadata{CollectedAt: at}, Region: &AwsRegion{}}
+		if _, err := store.Save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.Retention = retention
+
+	return store
+}
+
+func Test_SnapshotStore_Closest_should_return_the_snapshot_current_at_the_time(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) }
+	store := storeWith(t, RetentionPolicy{}, day(1), day(3), day(5))
+	defer os.RemoveAll(store.Dir)
+
+	stored, err := store.Closest(day(4))
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if !stored.CollectedAt.Equal(day(3)) {
+		t.Fatalf("stored.CollectedAt = %v, want %v", stored.CollectedAt, day(3))
+	}
+
+	if _, err = store.Closest(day(0)); err != SnapshotNotFound {
+		t.Fatalf("err = %v, want SnapshotNotFound before the first snapshot", err)
+	}
+}
+
+func Test_SnapshotStore_Prune_should_apply_the_retention_policy(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) }
+	store := storeWith(t, RetentionPolicy{MaxCount: 2, MaxAge: 96 * time.Hour}, day(1), day(2), day(3), day(4), day(5))
+	defer os.RemoveAll(store.Dir)
+
+	removed, err := store.Prune(day(6))
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if len(removed) != 3 {
+		t.Fatalf("len(removed) = %v, want 3", len(removed))
+	}
+
+	// the newest is kept even when it has expired.
+	removed, _ = store.Prune(day(30))
+	snapshots, _ := store.List()
+	if len(removed) != 1 || len(snapshots) != 1 {
+		t.Fatalf("len(snapshots) = %v, want 1", len(snapshots))
+	}
+}
+
+func Test_SnapshotStore_Before_should_return_the_previous_snapshot(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) }
+	store := storeWith(t, RetentionPolicy{}, day(1), day(3))
+	defer os.RemoveAll(store.Dir)
+
+	stored, err := store.Before(day(3).Add(500 * time.Millisecond))
+	if err != nil || !stored.CollectedAt.Equal(day(1)) {
+		t.Fatalf("stored = %v, %v, want the snapshot before %v", stored, err, day(3))
+	}
+
+	if _, err = store.Before(day(1)); err != SnapshotNotFound {
+		t.Fatalf("err = %v, want SnapshotNotFound", err)
+	}
+}
+
+func Test_SnapshotStore_Save_should_keep_snapshots_collected_in_the_same_second(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	store := storeWith(t, RetentionPolicy{}, at, at.Add(100*time.Millisecond), at.Add(200*time.Millisecond))
+	defer os.RemoveAll(store.Dir)
+
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if len(snapshots) != 3 {
+		t.Fatalf("len(snapshots) = %v, want 3", len(snapshots))
+	}
+
+	latest, err := store.Latest()
+	if err != nil || latest.Name != "snapshot-20261001T120000Z-3.json" {
+		t.Fatalf("latest = %+v, %v, want the third save", latest, err)
+	}
+}
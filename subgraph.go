@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SubgraphOptions select the nodes reached from Seeds within MaxDepth hops,
+// or every node when there are no seeds, along the edges and to the nodes
+// passing the traversal filters.
+type SubgraphOptions struct {
+	TraversalOptions
+	Seeds []string
+
+	// Containers adds the region, AZs, VPCs and subnets enclosing the
+	// selected nodes, so the subgraph can still be drawn as a tree.
+	Containers bool
+}
+
+// containedBy are the relationships from a node to the node enclosing it.
+var containedBy = map[Relationship]bool{
+	HostedBy:                true,
+	HousedBy:                true,
+	NetworkAllocatedBy:      true,
+	NetworkHostedBy:         true,
+	InstanceIpAllocatedFrom: true,
+	HomedIn:                 true,
+}
+
+// isContainment reports whether rel joins a node and its container, in either direction.
+func isContainment(rel Relationship) bool {
+	spec, ok := Relationships[rel]
+	return containedBy[rel] || (ok && containedBy[spec.Inverse])
+}
+
+// Subgraph returns a new graph of the selected nodes and the edges between
+// them passing the relationship filters. Nodes, their properties and sources
+// and the edge attributes are copied, so the subgraph can be modified without
+// affecting g.
+func (g *Graph) Subgraph(opts SubgraphOptions) (sub *Graph, err error) {
+	keep := make(map[string]bool)
+
+	if len(opts.Seeds) == 0 {
+		for _, n := range g.GetNodes(opts.Nodes...) {
+			keep[n.Id] = true
+		}
+	}
+
+	for _, seed := range opts.Seeds {
+		err = g.BFS(seed, opts.TraversalOptions, func(n NodeRef, depth int, via *Edge) bool {
+			keep[n.Id] = true
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Containers {
+		pending := make([]string, 0, len(keep))
+		for id := range keep {
+			pending = append(pending, id)
+		}
+
+		for len(pending) > 0 {
+			id := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+
+			for _, e := range g.Edges[id] {
+				if containedBy[e.Relationship] && !keep[e.To.Id] {
+					keep[e.To.Id] = true
+					pending = append(pending, e.To.Id)
+				}
+			}
+		}
+	}
+
+	sub = NewGraph()
+	for id := range keep {
+		n := *g.NodeList[id]
+		n.Properties = n.Properties.clone()
+		n.Sources = append([]string(nil), n.Sources...)
+		sub.NodeList[id] = &n
+	}
+
+	// edges are added in node order so the indexes are the same every time.
+	follows := TraversalOptions{Relationships: opts.Relationships}
+	for _, n := range sub.GetNodes() {
+		for _, e := range g.Edges[n.Id] {
+			if !keep[e.To.Id] {
+				continue
+			}
+
+			if (opts.Containers && isContainment(e.Relationship)) || follows.follows(e, 0) {
+				sub.AddNeighbour(n, e.Relationship, sub.NodeList[e.To.Id]).Attributes = e.Attributes.clone()
+			}
+		}
+	}
+
+	return sub, nil
+}
+
+// GraphSelection is a subgraph selection as given on the command line or in
+// a request: the nodes within Hops of the seeds, which may be short ids, of
+// the listed types and with the listed key=value properties, along the
+// listed relationships.
+type GraphSelection struct {
+	Seeds      stringList
+	Hops       int
+	Types      stringList
+	Rels       stringList
+	Props      stringList
+	Containers bool
+}
+
+// IsEmpty reports whether the selection selects the whole graph.
+func (s *GraphSelection) IsEmpty() bool {
+	return len(s.Seeds) == 0 && len(s.Types) == 0 && len(s.Rels) == 0 && len(s.Props) == 0
+}
+
+// Options resolves the selection against g.
+func (s *GraphSelection) Options(g *Graph) (opts SubgraphOptions, err error) {
+	opts.MaxDepth = s.Hops
+	opts.Containers = s.Containers
+
+	for _, seed := range s.Seeds {
+		n, err := g.Lookup(seed)
+		if err != nil {
+			return opts, fmt.Errorf("%v: %v", seed, err)
+		}
+		opts.Seeds = append(opts.Seeds, n.Id)
+	}
+
+	if len(s.Types) > 0 {
+		types := make(map[Type]bool)
+		for _, name := range s.Types {
+			t, err := ParseType(name)
+			if err != nil {
+				return opts, fmt.Errorf("%v: %v", name, err)
+			}
+			types[t] = true
+		}
+		opts.Nodes = append(opts.Nodes, func(n NodeRef) bool { return types[n.Type] })
+	}
+
+	for _, prop := range s.Props {
+		kv := strings.SplitN(prop, "=", 2)
+		if len(kv) != 2 {
+			return opts, fmt.Errorf("%v: want key=value", prop)
+		}
+		opts.Nodes = append(opts.Nodes, func(n NodeRef) bool { return nodeProperty(n, kv[0]) == kv[1] })
+	}
+
+	if len(s.Rels) > 0 {
+		rels := make([]Relationship, 0, len(s.Rels))
+		for _, r := range s.Rels {
+			rels = append(rels, Relationship(r))
+		}
+		opts.Relationships = append(opts.Relationships, IsRelationship(rels...))
+	}
+
+	return opts, nil
+}
+
+// Apply returns the selected subgraph of g, or g itself when nothing is selected.
+// containers forces the containers of the selected nodes to be kept.
+func (s *GraphSelection) Apply(g *Graph, containers bool) (*Graph, error) {
+	if s.IsEmpty() {
+		return g, nil
+	}
+
+	opts, err := s.Options(g)
+	if err != nil {
+		return nil, err
+	}
+	opts.Containers = opts.Containers || containers
+
+	return g.Subgraph(opts)
+}
+
+// selectionFromQuery reads a selection from request parameters, e.g.
+// ?seed=my-elb&hops=2 or ?prop=vpc=vpc-1&containers=true.
+func selectionFromQuery(q url.Values) (s *GraphSelection, err error) {
+	s = &GraphSelection{}
+	for _, v := range q["seed"] {
+		s.Seeds.Set(v)
+	}
+	for _, v := range q["type"] {
+		s.Types.Set(v)
+	}
+	for _, v := range q["rel"] {
+		s.Rels.Set(v)
+	}
+	s.Props = append(s.Props, q["prop"]...)
+
+	if hops := q.Get("hops"); hops != "" {
+		s.Hops, err = strconv.Atoi(hops)
+		if err != nil {
+			return nil, fmt.Errorf("hops: %v", err)
+		}
+	}
+
+	if containers := q.Get("containers"); containers != "" {
+		s.Containers, err = strconv.ParseBool(containers)
+		if err != nil {
+			return nil, fmt.Errorf("containers: %v", err)
+		}
+	}
+
+	return s, nil
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/awslabs/aws-sdk-go/aws"
+	"github.com/awslabs/aws-sdk-go/service/ec2"
+	"github.com/awslabs/aws-sdk-go/service/elb"
+)
+
+var UnsupportedStateVersion = errors.New("Unsupported Terraform state version, want 4!")
+
+// TerraformState is the subset of the v4 state format needed to map resources.
+type TerraformState struct {
+	Version          int                  `json:"version"`
+	TerraformVersion string               `json:"terraform_version"`
+	Resources        []*TerraformResource `json:"resources"`
+}
+
+// TerraformResource is a single resource block, which may have several instances (count/for_each).
+type TerraformResource struct {
+	Module    string                       `json:"module,omitempty"`
+	Mode      string                       `json:"mode"`
+	Type      string                       `json:"type"`
+	Name      string                       `json:"name"`
+	Instances []*TerraformResourceInstance `json:"instances"`
+}
+
+// TerraformResourceInstance
+type TerraformResourceInstance struct {
+	IndexKey   interface{}  `json:"index_key,omitempty"`
+	Attributes tfAttributes `json:"attributes"`
+}
+
+// Address returns the resource address as terraform would print it.
+func (r *TerraformResource) Address() string {
+	addr := r.Type + "." + r.Name
+	if r.Mode == "data" {
+		addr = "data." + addr
+	}
+
+	if r.Module != "" {
+		addr = r.Module + "." + addr
+	}
+
+	return addr
+}
+
+type tfAttributes map[string]interface{}
+
+func (a tfAttributes) String(key string) *string {
+	s, ok := a[key].(string)
+	if !ok || s == "" {
+		return nil
+	}
+
+	return aws.String(s)
+}
+
+func (a tfAttributes) Bool(key string) *bool {
+	b, ok := a[key].(bool)
+	if !ok {
+		return nil
+	}
+
+	return aws.Boolean(b)
+}
+
+func (a tfAttributes) Long(key string) *int64 {
+	f, ok := a[key].(float64)
+	if !ok {
+		return nil
+	}
+
+	return aws.Long(int64(f))
+}
+
+func (a tfAttributes) Strings(key string) (ss []*string) {
+	list, _ := a[key].([]interface{})
+	for _, v := range list {
+		if s, ok := v.(string); ok && s != "" {
+			ss = append(ss, aws.String(s))
+		}
+	}
+
+	return ss
+}
+
+func (a tfAttributes) Blocks(key string) (blocks []tfAttributes) {
+	list, _ := a[key].([]interface{})
+	for _, v := range list {
+		if m, ok := v.(map[string]interface{}); ok {
+			blocks = append(blocks, tfAttributes(m))
+		}
+	}
+
+	return blocks
+}
+
+// Tags converts the terraform tags map into sorted ec2 tags.
+func (a tfAttributes) Tags() (tags []*ec2.Tag) {
+	m, _ := a["tags"].(map[string]interface{})
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v, _ := m[k].(string)
+		tags = append(tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	return tags
+}
+
+// terraformImport accumulates the mapped resources while walking the state.
+type terraformImport struct {
+	region      *AwsRegion
+	acls        map[string]*ec2.NetworkACL
+	elbs        map[string]*elb.LoadBalancerDescription
+	gateways    map[string]*ec2.InternetGateway
+	routes      map[string]*ec2.RouteTable
+	secGroups   map[string]*ec2.SecurityGroup
+	subnetToVpc map[string]string
+	address     string
+	deferred    []deferredMapping
+	unmapped    []string
+}
+
+// deferredMapping attaches a resource to one that may appear later in the state.
+type deferredMapping struct {
+	address string
+	attach  func() bool
+}
+
+type tfMapper func(ti *terraformImport, attrs tfAttributes)
+
+var tfMappers = map[string]tfMapper{
+	"aws_vpc":                          mapTfVpc,
+	"aws_default_vpc":                  mapTfVpc,
+	"aws_subnet":                       mapTfSubnet,
+	"aws_default_subnet":               mapTfSubnet,
+	"aws_instance":                     mapTfInstance,
+	"aws_elb":                          mapTfElb,
+	"aws_elb_attachment":               mapTfElbAttachment,
+	"aws_security_group":               mapTfSecurityGroup,
+	"aws_default_security_group":       mapTfSecurityGroup,
+	"aws_security_group_rule":          mapTfSecurityGroupRule,
+	"aws_route_table":                  mapTfRouteTable,
+	"aws_default_route_table":          mapTfRouteTable,
+	"aws_route":                        mapTfRoute,
+	"aws_route_table_association":      mapTfRouteTableAssociation,
+	"aws_main_route_table_association": mapTfMainRouteTableAssociation,
+	"aws_internet_gateway":             mapTfInternetGateway,
+	"aws_internet_gateway_attachment":  mapTfInternetGatewayAttachment,
+	"aws_network_acl":                  mapTfNetworkAcl,
+	"aws_default_network_acl":          mapTfNetworkAcl,
+	"aws_network_acl_rule":             mapTfNetworkAclRule,
+}
+
+// ImportTerraformState maps the AWS resources in a v4 state file onto an AwsRegion.
+// The addresses of resources that have no mapping are returned as unmapped.
+func ImportTerraformState(r io.Reader) (region *AwsRegion, unmapped []string, err error) {
+	var state TerraformState
+
+	err = json.NewDecoder(r).Decode(&state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if state.Version != 4 {
+		return nil, nil, UnsupportedStateVersion
+	}
+
+	ti := &terraformImport{
+		region:      &AwsRegion{},
+		acls:        make(map[string]*ec2.NetworkACL),
+		elbs:        make(map[string]*elb.LoadBalancerDescription),
+		gateways:    make(map[string]*ec2.InternetGateway),
+		routes:      make(map[string]*ec2.RouteTable),
+		secGroups:   make(map[string]*ec2.SecurityGroup),
+		subnetToVpc: make(map[string]string),
+	}
+
+	for _, res := range state.Resources {
+		mapper, ok := tfMappers[res.Type]
+		if res.Mode != "managed" || !ok {
+			ti.unmapped = append(ti.unmapped, res.Address())
+			continue
+		}
+
+		ti.address = res.Address()
+		for _, inst := range res.Instances {
+			mapper(ti, inst.Attributes)
+		}
+	}
+
+	// rules, routes and associations can't be mapped when their parent isn't in the state.
+	for _, d := range ti.deferred {
+		if !d.attach() {
+			ti.unmapped = append(ti.unmapped, d.address)
+		}
+	}
+
+	for _, i := range ti.region.Instances {
+		if i.SubnetID != nil && i.VPCID == nil {
+			if vpc, ok := ti.subnetToVpc[*i.SubnetID]; ok {
+				i.VPCID = aws.String(vpc)
+			}
+		}
+	}
+
+	return ti.region, ti.unmapped, nil
+}
+
+func (ti *terraformImport) later(fn func() bool) {
+	ti.deferred = append(ti.deferred, deferredMapping{ti.address, fn})
+}
+
+func mapTfVpc(ti *terraformImport, a tfAttributes) {
+	ti.region.Vpcs = append(ti.region.Vpcs, &ec2.VPC{
+		VPCID:           a.String("id"),
+		CIDRBlock:       a.String("cidr_block"),
+		DHCPOptionsID:   a.String("dhcp_options_id"),
+		InstanceTenancy: a.String("instance_tenancy"),
+		State:           aws.String("available"),
+		Tags:            a.Tags(),
+	})
+}
+
+func mapTfSubnet(ti *terraformImport, a tfAttributes) {
+	sn := &ec2.Subnet{
+		SubnetID:            a.String("id"),
+		VPCID:               a.String("vpc_id"),
+		CIDRBlock:           a.String("cidr_block"),
+		AvailabilityZone:    a.String("availability_zone"),
+		MapPublicIPOnLaunch: a.Bool("map_public_ip_on_launch"),
+		State:               aws.String("available"),
+		Tags:                a.Tags(),
+	}
+
+	if sn.SubnetID != nil && sn.VPCID != nil {
+		ti.subnetToVpc[*sn.SubnetID] = *sn.VPCID
+	}
+
+	ti.region.Subnets = append(ti.region.Subnets, sn)
+}
+
+func mapTfInstance(ti *terraformImport, a tfAttributes) {
+	i := &ec2.Instance{
+		InstanceID:       a.String("id"),
+		ImageID:          a.String("ami"),
+		InstanceType:     a.String("instance_type"),
+		KeyName:          a.String("key_name"),
+		SubnetID:         a.String("subnet_id"),
+		PrivateIPAddress: a.String("private_ip"),
+		PrivateDNSName:   a.String("private_dns"),
+		PublicIPAddress:  a.String("public_ip"),
+		PublicDNSName:    a.String("public_dns"),
+		Placement:        &ec2.Placement{AvailabilityZone: a.String("availability_zone")},
+		State:            &ec2.InstanceState{Name: a.String("instance_state")},
+		Tags:             a.Tags(),
+	}
+
+	for _, sg := range a.Strings("vpc_security_group_ids") {
+		i.SecurityGroups = append(i.SecurityGroups, &ec2.GroupIdentifier{GroupID: sg})
+	}
+
+	// an instance without a subnet is in EC2-Classic, where security_groups are group names.
+	if i.SubnetID == nil {
+		for _, name := range a.Strings("security_groups") {
+			i.SecurityGroups = append(i.SecurityGroups, &ec2.GroupIdentifier{GroupName: name})
+		}
+	}
+
+	ti.region.Instances = append(ti.region.Instances, i)
+}
+
+func mapTfElb(ti *terraformImport, a tfAttributes) {
+	lb := &elb.LoadBalancerDescription{
+		LoadBalancerName:  a.String("name"),
+		DNSName:           a.String("dns_name"),
+		AvailabilityZones: a.Strings("availability_zones"),
+		Subnets:           a.Strings("subnets"),
+		SecurityGroups:    a.Strings("security_groups"),
+		Scheme:            aws.String("internet-facing"),
+	}
+
+	if internal := a.Bool("internal"); internal != nil && *internal {
+		lb.Scheme = aws.String("internal")
+	}
+
+	for _, id := range a.Strings("instances") {
+		lb.Instances = append(lb.Instances, &elb.Instance{InstanceID: id})
+	}
+
+	for _, l := range a.Blocks("listener") {
+		lb.ListenerDescriptions = append(lb.ListenerDescriptions, &elb.ListenerDescription{
+			Listener: &elb.Listener{
+				InstancePort:     l.Long("instance_port"),
+				InstanceProtocol: l.String("instance_protocol"),
+				LoadBalancerPort: l.Long("lb_port"),
+				Protocol:         l.String("lb_protocol"),
+				SSLCertificateID: l.String("ssl_certificate_id"),
+			},
+		})
+	}
+
+	if lb.LoadBalancerName != nil {
+		ti.elbs[*lb.LoadBalancerName] = lb
+	}
+
+	ti.region.LoadBalancers = append(ti.region.LoadBalancers, lb)
+}
+
+func mapTfElbAttachment(ti *terraformImport, a tfAttributes) {
+	name, instance := a.String("elb"), a.String("instance")
+	ti.later(func() bool {
+		lb, ok := ti.elbs[stringValue(name)]
+		if ok {
+			lb.Instances = append(lb.Instances, &elb.Instance{InstanceID: instance})
+		}
+		return ok
+	})
+}
+
+func tfIPPermission(rule tfAttributes, self *string) *ec2.IPPermission {
+	perm := &ec2.IPPermission{
+		FromPort:   rule.Long("from_port"),
+		ToPort:     rule.Long("to_port"),
+		IPProtocol: rule.String("protocol"),
+	}
+
+	if perm.IPProtocol != nil && *perm.IPProtocol == "-1" {
+		perm.FromPort, perm.ToPort = nil, nil
+	}
+
+	for _, cidr := range rule.Strings("cidr_blocks") {
+		perm.IPRanges = append(perm.IPRanges, &ec2.IPRange{CIDRIP: cidr})
+	}
+
+	groups := rule.Strings("security_groups")
+	if s := rule.String("source_security_group_id"); s != nil {
+		groups = append(groups, s)
+	}
+	if b := rule.Bool("self"); b != nil && *b {
+		groups = append(groups, self)
+	}
+
+	for _, g := range groups {
+		perm.UserIDGroupPairs = append(perm.UserIDGroupPairs, &ec2.UserIDGroupPair{GroupID: g})
+	}
+
+	return perm
+}
+
+func mapTfSecurityGroup(ti *terraformImport, a tfAttributes) {
+	sg := &ec2.SecurityGroup{
+		GroupID:     a.String("id"),
+		GroupName:   a.String("name"),
+		Description: a.String("description"),
+		OwnerID:     a.String("owner_id"),
+		VPCID:       a.String("vpc_id"),
+		Tags:        a.Tags(),
+	}
+
+	for _, rule := range a.Blocks("ingress") {
+		sg.IPPermissions = append(sg.IPPermissions, tfIPPermission(rule, sg.GroupID))
+	}
+
+	for _, rule := range a.Blocks("egress") {
+		sg.IPPermissionsEgress = append(sg.IPPermissionsEgress, tfIPPermission(rule, sg.GroupID))
+	}
+
+	if sg.GroupID != nil {
+		ti.secGroups[*sg.GroupID] = sg
+	}
+
+	ti.region.SecurityGroups = append(ti.region.SecurityGroups, sg)
+}
+
+func mapTfSecurityGroupRule(ti *terraformImport, a tfAttributes) {
+	id := a.String("security_group_id")
+	ti.later(func() bool {
+		sg, ok := ti.secGroups[stringValue(id)]
+		if !ok {
+			return false
+		}
+
+		perm := tfIPPermission(a, sg.GroupID)
+		if stringValue(a.String("type")) == "egress" {
+			sg.IPPermissionsEgress = append(sg.IPPermissionsEgress, perm)
+		} else {
+			sg.IPPermissions = append(sg.IPPermissions, perm)
+		}
+
+		return true
+	})
+}
+
+func tfRoute(r tfAttributes) *ec2.Route {
+	gateway := r.String("gateway_id")
+	if gateway == nil {
+		gateway = r.String("nat_gateway_id")
+	}
+
+	return &ec2.Route{
+		DestinationCIDRBlock:   firstString(r.String("cidr_block"), r.String("destination_cidr_block")),
+		GatewayID:              gateway,
+		InstanceID:             r.String("instance_id"),
+		NetworkInterfaceID:     r.String("network_interface_id"),
+		VPCPeeringConnectionID: r.String("vpc_peering_connection_id"),
+		Origin:                 aws.String("CreateRoute"),
+		State:                  aws.String("active"),
+	}
+}
+
+func mapTfRouteTable(ti *terraformImport, a tfAttributes) {
+	rt := &ec2.RouteTable{
+		RouteTableID: a.String("id"),
+		VPCID:        a.String("vpc_id"),
+		Tags:         a.Tags(),
+	}
+
+	for _, r := range a.Blocks("route") {
+		rt.Routes = append(rt.Routes, tfRoute(r))
+	}
+
+	// aws_default_route_table is always the main table of its VPC.
+	if a.String("default_route_table_id") != nil {
+		rt.Associations = append(rt.Associations, &ec2.RouteTableAssociation{
+			Main:         aws.Boolean(true),
+			RouteTableID: rt.RouteTableID,
+		})
+	}
+
+	if rt.RouteTableID != nil {
+		ti.routes[*rt.RouteTableID] = rt
+	}
+
+	ti.region.Routes = append(ti.region.Routes, rt)
+}
+
+func mapTfRoute(ti *terraformImport, a tfAttributes) {
+	id := a.String("route_table_id")
+	ti.later(func() bool {
+		rt, ok := ti.routes[stringValue(id)]
+		if ok {
+			rt.Routes = append(rt.Routes, tfRoute(a))
+		}
+		return ok
+	})
+}
+
+func mapTfRouteTableAssociation(ti *terraformImport, a tfAttributes) {
+	id := a.String("route_table_id")
+	ti.later(func() bool {
+		rt, ok := ti.routes[stringValue(id)]
+		if ok {
+			rt.Associations = append(rt.Associations, &ec2.RouteTableAssociation{
+				Main:                    aws.Boolean(false),
+				RouteTableAssociationID: a.String("id"),
+				RouteTableID:            id,
+				SubnetID:                a.String("subnet_id"),
+			})
+		}
+		return ok
+	})
+}
+
+func mapTfMainRouteTableAssociation(ti *terraformImport, a tfAttributes) {
+	id := a.String("route_table_id")
+	ti.later(func() bool {
+		rt, ok := ti.routes[stringValue(id)]
+		if ok {
+			rt.Associations = append(rt.Associations, &ec2.RouteTableAssociation{
+				Main:                    aws.Boolean(true),
+				RouteTableAssociationID: a.String("id"),
+				RouteTableID:            id,
+			})
+		}
+		return ok
+	})
+}
+
+func mapTfInternetGateway(ti *terraformImport, a tfAttributes) {
+	igw := &ec2.InternetGateway{
+		InternetGatewayID: a.String("id"),
+		Tags:              a.Tags(),
+	}
+
+	if vpc := a.String("vpc_id"); vpc != nil {
+		igw.Attachments = append(igw.Attachments, &ec2.InternetGatewayAttachment{
+			State: aws.String("available"),
+			VPCID: vpc,
+		})
+	}
+
+	if igw.InternetGatewayID != nil {
+		ti.gateways[*igw.InternetGatewayID] = igw
+	}
+
+	ti.region.Gateways = append(ti.region.Gateways, igw)
+}
+
+func mapTfInternetGatewayAttachment(ti *terraformImport, a tfAttributes) {
+	id := a.String("internet_gateway_id")
+	ti.later(func() bool {
+		igw, ok := ti.gateways[stringValue(id)]
+		if ok {
+			igw.Attachments = append(igw.Attachments, &ec2.InternetGatewayAttachment{
+				State: aws.String("available"),
+				VPCID: a.String("vpc_id"),
+			})
+		}
+		return ok
+	})
+}
+
+func tfAclEntry(e tfAttributes, egress bool) *ec2.NetworkACLEntry {
+	entry := &ec2.NetworkACLEntry{
+		CIDRBlock:  e.String("cidr_block"),
+		Egress:     aws.Boolean(egress),
+		Protocol:   tfProtocolNumber(e.String("protocol")),
+		RuleAction: e.String("action"),
+		RuleNumber: firstLong(e.Long("rule_no"), e.Long("rule_number")),
+	}
+
+	if entry.RuleAction == nil {
+		entry.RuleAction = e.String("rule_action")
+	}
+
+	if from, to := e.Long("from_port"), e.Long("to_port"); from != nil || to != nil {
+		entry.PortRange = &ec2.PortRange{From: from, To: to}
+	}
+
+	return entry
+}
+
+func mapTfNetworkAcl(ti *terraformImport, a tfAttributes) {
+	acl := &ec2.NetworkACL{
+		NetworkACLID: a.String("id"),
+		VPCID:        a.String("vpc_id"),
+		IsDefault:    aws.Boolean(a.String("default_network_acl_id") != nil),
+		Tags:         a.Tags(),
+	}
+
+	for _, e := range a.Blocks("ingress") {
+		acl.Entries = append(acl.Entries, tfAclEntry(e, false))
+	}
+
+	for _, e := range a.Blocks("egress") {
+		acl.Entries = append(acl.Entries, tfAclEntry(e, true))
+	}
+
+	for _, subnet := range a.Strings("subnet_ids") {
+		acl.Associations = append(acl.Associations, &ec2.NetworkACLAssociation{
+			NetworkACLID: acl.NetworkACLID,
+			SubnetID:     subnet,
+		})
+	}
+
+	if acl.NetworkACLID != nil {
+		ti.acls[*acl.NetworkACLID] = acl
+	}
+
+	ti.region.Acls = append(ti.region.Acls, acl)
+}
+
+func mapTfNetworkAclRule(ti *terraformImport, a tfAttributes) {
+	id := a.String("network_acl_id")
+	ti.later(func() bool {
+		acl, ok := ti.acls[stringValue(id)]
+		if ok {
+			egress := a.Bool("egress")
+			acl.Entries = append(acl.Entries, tfAclEntry(a, egress != nil && *egress))
+		}
+		return ok
+	})
+}
+
+// tfProtocolNumber normalises protocol names to the numbers the EC2 API reports.
+func tfProtocolNumber(p *string) *string {
+	switch strings.ToLower(stringValue(p)) {
+	case "all":
+		return aws.String("-1")
+	case "tcp":
+		return aws.String("6")
+	case "udp":
+		return aws.String("17")
+	case "icmp":
+		return aws.String("1")
+	}
+
+	return p
+}
+
+func firstString(ss ...*string) *string {
+	for _, s := range ss {
+		if s != nil {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func firstLong(ls ...*int64) *int64 {
+	for _, l := range ls {
+		if l != nil {
+			return l
+		}
+	}
+
+	return nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
@@ -0,0 +1,97 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+)
+import . "."
+
+const tfState = `{
+  "version": 4,
+  "terraform_version": "1.5.7",
+  "resources": [
+    {"mode": "managed", "type": "aws_vpc", "name": "main", "instances": [
+      {"attributes": {"id": "vpc-1", "cidr_block": "10.0.0.0/16", "tags": {"Name": "main"}}}
+    ]},
+    {"mode": "managed", "type": "aws_subnet", "name": "a", "instances": [
+      {"attributes": {"id": "subnet-1", "vpc_id": "vpc-1", "cidr_block": "10.0.1.0/24", "availability_zone": "eu-west-1a"}}
+    ]},
+    {"mode": "managed", "type": "aws_security_group_rule", "name": "ssh", "instances": [
+      {"attributes": {"security_group_id": "sg-1", "type": "ingress", "from_port": 22, "to_port": 22, "protocol": "tcp", "cidr_blocks": ["0.0.0.0/0"]}}
+    ]},
+    {"mode": "managed", "type": "aws_security_group", "name": "web", "instances": [
+      {"attributes": {"id": "sg-1", "name": "web", "vpc_id": "vpc-1", "ingress": [], "egress": []}}
+    ]},
+    {"mode": "managed", "type": "aws_instance", "name": "web", "instances": [
+      {"attributes": {"id": "i-1", "subnet_id": "subnet-1", "availability_zone": "eu-west-1a", "vpc_security_group_ids": ["sg-1"]}}
+    ]},
+    {"mode": "managed", "type": "aws_elb", "name": "web", "instances": [
+      {"attributes": {"name": "web", "subnets": ["subnet-1"], "instances": ["i-1"], "listener": [{"lb_port": 80, "instance_port": 8080, "lb_protocol": "http", "instance_protocol": "http"}]}}
+    ]},
+    {"mode": "managed", "type": "aws_s3_bucket", "name": "logs", "instances": [{"attributes": {"id": "logs"}}]},
+    {"mode": "data", "type": "aws_ami", "name": "ubuntu", "instances": [{"attributes": {"id": "ami-1"}}]}
+  ]
+}`
+
+func Test_ImportTerraformState_should_map_supported_resources(t *testing.T) {
+	region, _, err := ImportTerraformState(strings.NewReader(tfState))
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	if len(region.Vpcs) != 1 || len(region.Subnets) != 1 || len(region.Instances) != 1 || len(region.LoadBalancers) != 1 {
+		t.Fatalf("region = %+v, want 1 vpc, subnet, instance and elb", region)
+	}
+
+	if *region.Instances[0].VPCID != "vpc-1" {
+		t.Fatalf("instance.VPCID = %v, want vpc-1", *region.Instances[0].VPCID)
+	}
+
+	perms := region.SecurityGroups[0].IPPermissions
+	if len(perms) != 1 || *perms[0].FromPort != 22 {
+		t.Fatalf("len(IPPermissions) = %v, want the ssh rule attached", len(perms))
+	}
+}
+
+func Test_ImportTerraformState_should_list_unmapped_resources(t *testing.T) {
+	_, unmapped, _ := ImportTerraformState(strings.NewReader(tfState))
+
+	want := "aws_s3_bucket.logs,data.aws_ami.ubuntu"
+	if strings.Join(unmapped, ",") != want {
+		t.Fatalf("unmapped = %v, want %v", unmapped, want)
+	}
+}
+
+func Test_ImportTerraformState_should_build_a_graph_of_instances_with_and_without_subnets(t *testing.T) {
+	state := strings.Replace(tfState, `{"mode": "managed", "type": "aws_elb"`, `{"mode": "managed", "type": "aws_instance", "name": "classic", "instances": [
+      {"attributes": {"id": "i-classic", "availability_zone": "eu-west-1a", "security_groups": ["legacy"]}}
+    ]},
+    {"mode": "managed", "type": "aws_elb"`, 1)
+
+	region, _, err := ImportTerraformState(strings.NewReader(state))
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+
+	graph, report := BuildGraphReport(&Config{Region: "eu-west-1"}, region)
+
+	edges := graph.Query().From("eu-west-1/instance/i-1").Edges()
+	if len(edges) != 3 {
+		t.Errorf("i-1 edges = %v, want it in subnet-1, behind the elb and in sg-1", edges)
+	}
+
+	if _, err := graph.GetNode("eu-west-1/instance/i-classic"); err != nil {
+		t.Fatalf("err = %v, want the classic instance in the graph", err)
+	}
+
+	if len(report.Unresolved) != 1 || report.Unresolved[0].Referrer != "eu-west-1/instance/i-classic" || report.Unresolved[0].Type != Subnet {
+		t.Errorf("unresolved = %+v, want only the classic instance's subnet", report.Unresolved)
+	}
+}
+
+func Test_ImportTerraformState_should_reject_old_state_versions(t *testing.T) {
+	_, _, err := ImportTerraformState(strings.NewReader(`{"version": 3}`))
+	if err != UnsupportedStateVersion {
+		t.Fatalf("err = %v, want UnsupportedStateVersion", err)
+	}
+}
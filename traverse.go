@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var PathNotFound = errors.New("Path not found!")
+var UnboundedDepth = errors.New("Depth must be at least 1 hop!")
+
+// TraversalOptions restrict a traversal to the edges passing every
+// relationship filter and the nodes passing every node filter. The start
+// node is always visited. MaxDepth limits the hops taken, 0 is unlimited.
+type TraversalOptions struct {
+	Relationships []RelationshipFilterFunc
+	Nodes         []NodeFilterFunc
+	MaxDepth      int
+}
+
+func (o *TraversalOptions) follows(e *Edge, depth int) bool {
+	if o.MaxDepth > 0 && depth >= o.MaxDepth {
+		return false
+	}
+
+	for _, fn := range o.Relationships {
+		if !fn(e) {
+			return false
+		}
+	}
+
+	for _, fn := range o.Nodes {
+		if !fn(e.To) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VisitFunc is called with each node reached, its depth and the edge it was
+// reached by, nil for the start node. Returning false stops the traversal.
+type VisitFunc func(n NodeRef, depth int, via *Edge) bool
+
+// Path is a sequence of edges, each starting where the previous one ended.
+type Path []*Edge
+
+// String renders the path as (a) -[rel]-> (b) -[rel]-> (c).
+func (p Path) String() string {
+	if len(p) == 0 {
+		return ""
+	}
+
+	parts := []string{"(" + p[0].From.Id + ")"}
+	for _, e := range p {
+		parts = append(parts, "-["+string(e.Relationship)+"]->", "("+e.To.Id+")")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// BFS visits the nodes reachable from start breadth first, nearest first.
+func (g *Graph) BFS(start string, opts TraversalOptions, visit VisitFunc) error {
+	n, err := g.GetNode(start)
+	if err != nil {
+		return err
+	}
+
+	type step struct {
+		node  NodeRef
+		depth int
+	}
+
+	seen := map[string]bool{start: true}
+	queue := []step{{n, 0}}
+	if !visit(n, 0, nil) {
+		return nil
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, e := range g.Edges[current.node.Id] {
+			if seen[e.To.Id] || !opts.follows(e, current.depth) {
+				continue
+			}
+			seen[e.To.Id] = true
+
+			if !visit(e.To, current.depth+1, e) {
+				return nil
+			}
+			queue = append(queue, step{e.To, current.depth + 1})
+		}
+	}
+
+	return nil
+}
+
+// DFS visits the nodes reachable from start depth first, each once. With
+// MaxDepth a node first reached by a long path is walked again from a shorter
+// one, so none of its descendants within the depth are missed.
+func (g *Graph) DFS(start string, opts TraversalOptions, visit VisitFunc) error {
+	n, err := g.GetNode(start)
+	if err != nil {
+		return err
+	}
+
+	// depths holds the fewest hops each node has been reached in.
+	depths := map[string]int{start: 0}
+	var walk func(n NodeRef, depth int, via *Edge, first bool) bool
+	walk = func(n NodeRef, depth int, via *Edge, first bool) bool {
+		if first && !visit(n, depth, via) {
+			return false
+		}
+
+		for _, e := range g.Edges[n.Id] {
+			reached, seen := depths[e.To.Id]
+			if (seen && (opts.MaxDepth == 0 || reached <= depth+1)) || !opts.follows(e, depth) {
+				continue
+			}
+			depths[e.To.Id] = depth + 1
+
+			if !walk(e.To, depth+1, e, !seen) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	walk(n, 0, nil, true)
+
+	return nil
+}
+
+// ShortestPath returns a path from one node to another with the fewest hops.
+func (g *Graph) ShortestPath(from, to string, opts TraversalOptions) (p Path, err error) {
+	if _, err = g.GetNode(to); err != nil {
+		return nil, err
+	}
+
+	via := make(map[string]*Edge)
+	found := from == to
+	err = g.BFS(from, opts, func(n NodeRef, depth int, e *Edge) bool {
+		if e != nil {
+			via[n.Id] = e
+		}
+		found = found || n.Id == to
+
+		return !found
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, PathNotFound
+	}
+
+	for id := to; id != from; id = via[id].From.Id {
+		p = append(Path{via[id]}, p...)
+	}
+
+	return p, nil
+}
+
+// AllPaths returns every simple path, visiting no node twice, from one node
+// to another of at most maxDepth hops. maxDepth overrides opts.MaxDepth and
+// must be positive, the number of paths grows exponentially with it.
+func (g *Graph) AllPaths(from, to string, maxDepth int, opts TraversalOptions) (paths []Path, err error) {
+	if maxDepth < 1 {
+		return nil, UnboundedDepth
+	}
+
+	if _, err = g.GetNode(from); err != nil {
+		return nil, err
+	}
+
+	if _, err = g.GetNode(to); err != nil {
+		return nil, err
+	}
+
+	opts.MaxDepth = maxDepth
+	onPath := map[string]bool{from: true}
+	var current Path
+
+	var walk func(id string)
+	walk = func(id string) {
+		for _, e := range g.Edges[id] {
+			if onPath[e.To.Id] || !opts.follows(e, len(current)) {
+				continue
+			}
+
+			current = append(current, e)
+			if e.To.Id == to {
+				paths = append(paths, append(Path{}, current...))
+			} else {
+				onPath[e.To.Id] = true
+				walk(e.To.Id)
+				onPath[e.To.Id] = false
+			}
+			current = current[:len(current)-1]
+		}
+	}
+
+	walk(from)
+
+	if len(paths) == 0 {
+		return nil, PathNotFound
+	}
+
+	return paths, nil
+}
+
+// IsRelationship matches edges with any of the relationships.
+func IsRelationship(rels ...Relationship) RelationshipFilterFunc {
+	return func(e *Edge) bool {
+		for _, rel := range rels {
+			if e.Relationship == rel {
+				return true
+			}
+		}
+
+		return false
+	}
+}